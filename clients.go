@@ -16,60 +16,109 @@ func loadClient() (clients.SecretsManagerClient, clients.SecretsManager, error)
 		case "k8s":
 			var hvK8SOpts HashicorpVaultK8SOptions
 			parseArgs(&hvK8SOpts)
-			smc, mgr := loadHashiCorpVaultClient(opts, hvOpts, clients.HashicorpVaultK8SAuth{IsPod: opts.IsPod, Role: hvK8SOpts.Role, ConfigPath: opts.KubeconfigPath})
-			return smc, mgr, nil
+			return loadHashiCorpVaultClient(opts, hvOpts, clients.HashicorpVaultK8SAuth{IsPod: opts.IsPod, Role: hvK8SOpts.Role, ConfigPath: opts.KubeconfigPath, MountPath: hvOpts.AuthMount})
 		case "approle":
 			var hvAppRoleOpts HashicorpVaultAppRoleOptions
 			parseArgs(&hvAppRoleOpts)
-			smc, mgr := loadHashiCorpVaultClient(opts, hvOpts, clients.HashicorpVaultApproleAuth{RoleID: hvAppRoleOpts.RoleID, SecretID: hvAppRoleOpts.SecretID})
-			return smc, mgr, nil
+			return loadHashiCorpVaultClient(opts, hvOpts, clients.HashicorpVaultApproleAuth{RoleID: hvAppRoleOpts.RoleID, SecretID: hvAppRoleOpts.SecretID, MountPath: hvOpts.AuthMount})
 		case "token":
 			var hvTokenOpts HashicorpVaultTokenOptions
 			parseArgs(&hvTokenOpts)
-			smc, mgr := loadHashiCorpVaultClient(opts, hvOpts, clients.HashicorpVaultTokenAuth{Token: hvTokenOpts.Token})
-			return smc, mgr, nil
+			return loadHashiCorpVaultClient(opts, hvOpts, clients.HashicorpVaultTokenAuth{Token: hvTokenOpts.Token})
+		case "jwt":
+			var hvJWTOpts HashicorpVaultJWTOptions
+			parseArgs(&hvJWTOpts)
+			return loadHashiCorpVaultClient(opts, hvOpts, clients.HashicorpVaultJWTAuth{Role: hvJWTOpts.Role, TokenPath: hvJWTOpts.TokenPath, MountPath: hvOpts.AuthMount})
+		case "cert":
+			var hvCertOpts HashicorpVaultCertOptions
+			parseArgs(&hvCertOpts)
+			return loadHashiCorpVaultClient(opts, hvOpts, clients.HashicorpVaultCertAuth{Name: hvCertOpts.Name, CertPath: hvCertOpts.CertPath, KeyPath: hvCertOpts.KeyPath, SkipTLSVerify: hvOpts.SkipTLSVerify, MountPath: hvOpts.AuthMount})
+		case "userpass":
+			var hvUserpassOpts HashicorpVaultUserpassOptions
+			parseArgs(&hvUserpassOpts)
+			return loadHashiCorpVaultClient(opts, hvOpts, clients.HashicorpVaultUserpassAuth{Username: hvUserpassOpts.Username, Password: hvUserpassOpts.Password, MountPath: hvOpts.AuthMount})
 		default:
 			return nil, "", errors.New("Unknown Hashicorp Vault authentication type")
 		}
 	case "aws":
 		var awsOpts AWSOptions
 		parseArgs(&awsOpts)
-		switch awsOpts.Authentication {
-		case "iam":
-			smc, mgr := loadAWSClient(opts, awsOpts, &clients.AWSIAMAuth{})
-			return smc, mgr, nil
-		case "static":
-			var staticAWSOpts AWSCredentialsOptions
-			parseArgs(&staticAWSOpts)
-			smc, mgr := loadAWSClient(opts, awsOpts, &clients.AWSStaticCredentialsAuth{AccessKeyID: staticAWSOpts.AccessKeyID, SecretAccessKey: staticAWSOpts.SecretAccessKey})
-			return smc, mgr, nil
-		case "env":
-			smc, mgr := loadAWSClient(opts, awsOpts, &clients.AWSEnvironmentAuth{})
-			return smc, mgr, nil
-		case "shared":
-			var awsSharedOpts AWSSharedOptions
-			parseArgs(&awsSharedOpts)
-			smc, mgr := loadAWSClient(opts, awsOpts, &clients.AWSSharedCredentialsAuth{Path: awsSharedOpts.Path, Profile: awsSharedOpts.Profile})
-			return smc, mgr, nil
-		default:
-			return nil, "", errors.New("Unknown AWS authentication type")
+		auth, err := loadAWSAuth(awsOpts)
+		if err != nil {
+			return nil, "", err
+		}
+		return loadAWSClient(opts, awsOpts, auth)
+	case "ssm":
+		var awsOpts AWSOptions
+		parseArgs(&awsOpts)
+		var ssmOpts AWSParameterStoreOptions
+		parseArgs(&ssmOpts)
+		auth, err := loadAWSAuth(awsOpts)
+		if err != nil {
+			return nil, "", err
 		}
+		return loadAWSParameterStoreClient(opts, awsOpts, ssmOpts, auth)
 	case "azure":
 		var azOpts AzureKeyVaultOptions
 		parseArgs(&azOpts)
 		switch azOpts.Authentication {
 		case "env":
-			smc, mgr := loadAzureKeyVaultClient(opts, azOpts, &clients.AzureKeyVaultEnvironmentAuth{})
-			return smc, mgr, nil
+			return loadAzureKeyVaultClient(opts, azOpts, &clients.AzureKeyVaultEnvironmentAuth{})
 		case "file":
 			var azFileOpts AzureKeyVaultFileOptions
 			parseArgs(&azFileOpts)
-			smc, mgr := loadAzureKeyVaultClient(opts, azOpts, &clients.AzureKeyVaultFileAuth{BaseURI: azFileOpts.FilePath})
-			return smc, mgr, nil
+			return loadAzureKeyVaultClient(opts, azOpts, &clients.AzureKeyVaultFileAuth{BaseURI: azFileOpts.FilePath})
 		default:
 			return nil, "", errors.New("Unknown Azure authentication type")
 		}
+	case "gcp":
+		var gcpOpts GCPOptions
+		parseArgs(&gcpOpts)
+		switch gcpOpts.Authentication {
+		case "file":
+			var gcpFileOpts GCPFileOptions
+			parseArgs(&gcpFileOpts)
+			return loadGCPClient(opts, gcpOpts, &clients.GCPServiceAccountFileAuth{Path: gcpFileOpts.FilePath})
+		case "metadata":
+			return loadGCPClient(opts, gcpOpts, &clients.GCPMetadataAuth{})
+		default:
+			return nil, "", errors.New("Unknown GCP authentication type")
+		}
 	default:
 		return nil, "", errors.New("Failed to load a configured secrets backend properly")
 	}
 }
+
+// loadAWSAuth loads the configured authentication, shared by the AWS backends
+func loadAWSAuth(awsOpts AWSOptions) (clients.AWSSecretsAuth, error) {
+	switch awsOpts.Authentication {
+	case "iam":
+		return &clients.AWSIAMAuth{}, nil
+	case "static":
+		var staticAWSOpts AWSCredentialsOptions
+		parseArgs(&staticAWSOpts)
+		return &clients.AWSStaticCredentialsAuth{AccessKeyID: staticAWSOpts.AccessKeyID, SecretAccessKey: staticAWSOpts.SecretAccessKey}, nil
+	case "env":
+		return &clients.AWSEnvironmentAuth{}, nil
+	case "shared":
+		var awsSharedOpts AWSSharedOptions
+		parseArgs(&awsSharedOpts)
+		return &clients.AWSSharedCredentialsAuth{Path: awsSharedOpts.Path, Profile: awsSharedOpts.Profile}, nil
+	case "webidentity":
+		var awsWebIdentityOpts AWSWebIdentityOptions
+		parseArgs(&awsWebIdentityOpts)
+		return &clients.AWSWebIdentityAuth{RoleARN: awsWebIdentityOpts.RoleARN, SessionName: awsWebIdentityOpts.SessionName, TokenPath: awsWebIdentityOpts.TokenPath}, nil
+	case "assumerole":
+		var awsAssumeRoleOpts AWSAssumeRoleOptions
+		parseArgs(&awsAssumeRoleOpts)
+		return &clients.AWSAssumeRoleAuth{
+			RoleARN:              awsAssumeRoleOpts.RoleARN,
+			SessionName:          awsAssumeRoleOpts.SessionName,
+			ExternalID:           awsAssumeRoleOpts.ExternalID,
+			WebIdentityRoleARN:   awsAssumeRoleOpts.WebIdentityRoleARN,
+			WebIdentityTokenPath: awsAssumeRoleOpts.TokenPath,
+		}, nil
+	default:
+		return nil, errors.New("Unknown AWS authentication type")
+	}
+}
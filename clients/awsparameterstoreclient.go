@@ -0,0 +1,148 @@
+package clients
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// awsParameterStoreClient holds the AWS Client and root path needed for integration with AWS
+// Systems Manager Parameter Store
+type awsParameterStoreClient struct {
+	Client ssmiface.SSMAPI
+	root   string
+}
+
+// NewAWSParameterStoreClient provides a new SecretsManagerClient for using AWS Systems Manager
+// Parameter Store. Parameters are read from the hierarchy /<root>/<namespace>/<secret>/<key>,
+// where the root is optional
+func NewAWSParameterStoreClient(auth AWSSecretsAuth, root string) (SecretsManagerClient, error) {
+	cfg, err := auth.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	sess, err := session.NewSession(cfg)
+	return &awsParameterStoreClient{
+		Client: ssm.New(sess),
+		root:   strings.Trim(root, "/"),
+	}, err
+}
+
+// parameterPath provides the full path of the parameters below a path relative to the root
+func (client awsParameterStoreClient) parameterPath(path string) string {
+	if client.root == "" {
+		return fmt.Sprintf("/%s", strings.Trim(path, "/"))
+	}
+	return fmt.Sprintf("/%s/%s", client.root, strings.Trim(path, "/"))
+}
+
+// GetSecrets will provide a slice of Secret type responses, for the parameters below the path of
+// each namespace in AWS Systems Manager Parameter Store
+func (client awsParameterStoreClient) GetSecrets(namespaces ...string) ([]*Secret, error) {
+	sc := make(chan *Secret)
+	wg := &sync.WaitGroup{}
+	for _, namespace := range namespaces {
+		wg.Add(1)
+		go func(namespace string) {
+			defer wg.Done()
+			nsPath := client.parameterPath(namespace)
+			parameters, err := client.getParametersByPath(nsPath)
+			if err != nil {
+				log.Printf("Failed to load the parameters under %s: %s\n", nsPath, err.Error())
+				return
+			}
+			for _, secret := range buildSecretsFromParameters(namespace, nsPath, parameters) {
+				sc <- secret
+			}
+		}(namespace)
+	}
+
+	go func() {
+		wg.Wait()
+		close(sc)
+	}()
+
+	secrets := make([]*Secret, 0)
+	for secret := range sc {
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+// GetSecret will retrieve the parameters below a path in AWS Systems Manager Parameter Store as a
+// single secret, with the path given as <namespace>/<secret>
+func (client awsParameterStoreClient) GetSecret(path string) (*Secret, error) {
+	secretPath := client.parameterPath(path)
+	parameters, err := client.getParametersByPath(secretPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(parameters) == 0 {
+		return nil, fmt.Errorf("No parameters were found under %s", secretPath)
+	}
+
+	splitPaths := strings.Split(strings.Trim(path, "/"), "/")
+	lastPath := len(splitPaths) - 1
+	secret := &Secret{Name: splitPaths[lastPath], Data: make(map[string]string)}
+	if lastPath > 0 {
+		secret.Namespace = strings.Join(splitPaths[:lastPath], "/")
+	}
+	for _, parameter := range parameters {
+		key := parameterKey(strings.TrimPrefix(*parameter.Name, secretPath+"/"))
+		secret.Data[key] = aws.StringValue(parameter.Value)
+	}
+	return secret, nil
+}
+
+// getParametersByPath loads every parameter nested below a path, decrypting any secure strings
+func (client awsParameterStoreClient) getParametersByPath(path string) ([]*ssm.Parameter, error) {
+	parameters := make([]*ssm.Parameter, 0)
+	err := client.Client.GetParametersByPathPages(&ssm.GetParametersByPathInput{
+		Path:           aws.String(path),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	}, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+		parameters = append(parameters, page.Parameters...)
+		return true
+	})
+	return parameters, err
+}
+
+// buildSecretsFromParameters groups the parameters below the path of a namespace into secrets. The
+// first directory below the namespace names the secret, and the rest of the path is the key, with
+// any further directories joined by dots. Parameters directly below the namespace are skipped, as
+// they do not belong to a secret
+func buildSecretsFromParameters(namespace, nsPath string, parameters []*ssm.Parameter) []*Secret {
+	secrets := make([]*Secret, 0)
+	byName := make(map[string]*Secret)
+	for _, parameter := range parameters {
+		if parameter.Name == nil {
+			continue
+		}
+		relPath := strings.TrimPrefix(*parameter.Name, nsPath+"/")
+		parts := strings.SplitN(relPath, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			log.Printf("Skipping parameter %s, it is not below a secret in %s\n", *parameter.Name, nsPath)
+			continue
+		}
+		secret, ok := byName[parts[0]]
+		if !ok {
+			secret = &Secret{Name: parts[0], Namespace: namespace, Data: make(map[string]string)}
+			byName[parts[0]] = secret
+			secrets = append(secrets, secret)
+		}
+		secret.Data[parameterKey(parts[1])] = aws.StringValue(parameter.Value)
+	}
+	return secrets
+}
+
+// parameterKey converts the path of a parameter below its secret into a key of the k8s secret
+func parameterKey(path string) string {
+	return strings.Replace(path, "/", ".", -1)
+}
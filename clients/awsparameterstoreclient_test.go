@@ -0,0 +1,82 @@
+package clients
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// mockSSMClient serves the parameters below a path in two pages
+type mockSSMClient struct {
+	ssmiface.SSMAPI
+	parameters map[string][]*ssm.Parameter
+}
+
+func (client mockSSMClient) GetParametersByPathPages(input *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+	parameters := client.parameters[*input.Path]
+	half := len(parameters) / 2
+	if fn(&ssm.GetParametersByPathOutput{Parameters: parameters[:half]}, false) {
+		fn(&ssm.GetParametersByPathOutput{Parameters: parameters[half:]}, true)
+	}
+	return nil
+}
+
+func TestBuildSecretsFromParameters(t *testing.T) {
+	parameters := []*ssm.Parameter{
+		{Name: aws.String("/mimir/default/app/username"), Value: aws.String("mimir")},
+		{Name: aws.String("/mimir/default/app/db/password"), Value: aws.String("secret")},
+		{Name: aws.String("/mimir/default/other/hosts"), Value: aws.String("a,b")},
+		{Name: aws.String("/mimir/default/loose"), Value: aws.String("skipped")},
+	}
+
+	secrets := buildSecretsFromParameters("default", "/mimir/default", parameters)
+	if len(secrets) != 2 {
+		t.Fatalf("Expected 2 secrets, got %d", len(secrets))
+	}
+	if secrets[0].Name != "app" || secrets[0].Namespace != "default" {
+		t.Errorf("Unexpected secret %s/%s", secrets[0].Namespace, secrets[0].Name)
+	}
+	if secrets[0].Data["username"] != "mimir" || secrets[0].Data["db.password"] != "secret" {
+		t.Errorf("Unexpected data %v", secrets[0].Data)
+	}
+	if secrets[1].Name != "other" || secrets[1].Data["hosts"] != "a,b" {
+		t.Errorf("Unexpected secret %s with data %v", secrets[1].Name, secrets[1].Data)
+	}
+}
+
+func TestAWSParameterStoreClient(t *testing.T) {
+	client := awsParameterStoreClient{
+		Client: mockSSMClient{parameters: map[string][]*ssm.Parameter{
+			"/mimir/default": {
+				{Name: aws.String("/mimir/default/app/username"), Value: aws.String("mimir")},
+				{Name: aws.String("/mimir/default/app/password"), Value: aws.String("secret")},
+			},
+			"/mimir/default/app": {
+				{Name: aws.String("/mimir/default/app/username"), Value: aws.String("mimir")},
+			},
+		}},
+		root: "mimir",
+	}
+
+	secrets, err := client.GetSecrets("default", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 1 || len(secrets[0].Data) != 2 {
+		t.Fatalf("Expected a single secret with 2 keys, got %v", secrets)
+	}
+
+	secret, err := client.GetSecret("default/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Name != "app" || secret.Namespace != "default" || secret.Data["username"] != "mimir" {
+		t.Errorf("Unexpected secret %+v", secret)
+	}
+
+	if _, err := client.GetSecret("default/missing"); err == nil {
+		t.Error("Expected an error for a path with no parameters")
+	}
+}
@@ -4,11 +4,18 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
 )
 
+// awsCredentialsExpiryWindow is how long before temporary credentials expire that they are refreshed
+const awsCredentialsExpiryWindow = time.Minute
+
 // AWSSecretsAuth interface provides a common function set to authenticate with AWS from mimir
 type AWSSecretsAuth interface {
 	SetRegion(region string) error
@@ -100,3 +107,95 @@ func (auth AWSSharedCredentialsAuth) GetConfig() (*aws.Config, error) {
 
 	return aws.NewConfig().WithRegion(auth.Region).WithCredentials(credentials.NewSharedCredentials(path, profile)), nil
 }
+
+// AWSWebIdentityAuth contains auth information for exchanging a web identity token, such as the
+// projected service account token of EKS IAM roles for service accounts, for role credentials
+type AWSWebIdentityAuth struct {
+	RoleARN     string
+	SessionName string
+	TokenPath   string
+	AWSRegion
+}
+
+// GetConfig will load the AWS config for web identity credentials. The role and token file default
+// to the AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE environment variables set by EKS
+func (auth AWSWebIdentityAuth) GetConfig() (*aws.Config, error) {
+	creds, err := newAWSWebIdentityCredentials(auth.Region, auth.RoleARN, auth.SessionName, auth.TokenPath)
+	if err != nil {
+		return nil, err
+	}
+	return aws.NewConfig().WithRegion(auth.Region).WithCredentials(creds), nil
+}
+
+// AWSAssumeRoleAuth contains auth information for assuming a role, such as a role in another
+// account. The role is assumed with the IAM credentials of mimir, or with the credentials of a
+// web identity when a token file is set
+type AWSAssumeRoleAuth struct {
+	RoleARN     string
+	SessionName string
+	ExternalID  string
+	// WebIdentityRoleARN and WebIdentityTokenPath provide the credentials the role is assumed with
+	WebIdentityRoleARN   string
+	WebIdentityTokenPath string
+	AWSRegion
+}
+
+// GetConfig will load the AWS config for assumed role credentials
+func (auth AWSAssumeRoleAuth) GetConfig() (*aws.Config, error) {
+	if auth.RoleARN == "" {
+		return nil, errors.New("Requires a role ARN to assume")
+	}
+	sourceConfig := aws.NewConfig().WithRegion(auth.Region)
+	if auth.WebIdentityTokenPath != "" {
+		creds, err := newAWSWebIdentityCredentials(auth.Region, auth.WebIdentityRoleARN, auth.SessionName, auth.WebIdentityTokenPath)
+		if err != nil {
+			return nil, err
+		}
+		sourceConfig = sourceConfig.WithCredentials(creds)
+	}
+	sess, err := session.NewSession(sourceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := stscreds.NewCredentials(sess, auth.RoleARN, func(provider *stscreds.AssumeRoleProvider) {
+		provider.RoleSessionName = awsSessionName(auth.SessionName)
+		provider.ExpiryWindow = awsCredentialsExpiryWindow
+		if auth.ExternalID != "" {
+			provider.ExternalID = aws.String(auth.ExternalID)
+		}
+	})
+	return aws.NewConfig().WithRegion(auth.Region).WithCredentials(creds), nil
+}
+
+// newAWSWebIdentityCredentials provides credentials for a role from a web identity token, which the
+// SDK provider reads again each time the credentials are refreshed, as projected tokens are rotated
+func newAWSWebIdentityCredentials(region, roleARN, sessionName, tokenPath string) (*credentials.Credentials, error) {
+	if roleARN == "" {
+		roleARN = os.Getenv("AWS_ROLE_ARN")
+	}
+	if tokenPath == "" {
+		tokenPath = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	if roleARN == "" {
+		return nil, errors.New("Requires a role ARN for the web identity, or the AWS_ROLE_ARN environment variable")
+	}
+	if tokenPath == "" {
+		return nil, errors.New("Requires a web identity token file, or the AWS_WEB_IDENTITY_TOKEN_FILE environment variable")
+	}
+	sess, err := session.NewSession(aws.NewConfig().WithRegion(region).WithCredentials(credentials.AnonymousCredentials))
+	if err != nil {
+		return nil, err
+	}
+	provider := stscreds.NewWebIdentityRoleProvider(sts.New(sess), roleARN, awsSessionName(sessionName), tokenPath)
+	provider.ExpiryWindow = awsCredentialsExpiryWindow
+	return credentials.NewCredentials(provider), nil
+}
+
+// awsSessionName provides the name of a role session, defaulting to mimir
+func awsSessionName(sessionName string) string {
+	if sessionName == "" {
+		return "mimir"
+	}
+	return sessionName
+}
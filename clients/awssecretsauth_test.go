@@ -0,0 +1,20 @@
+package clients
+
+import (
+	"os"
+	"testing"
+)
+
+func TestAWSRoleAuthValidation(t *testing.T) {
+	if _, err := (AWSAssumeRoleAuth{}).GetConfig(); err == nil {
+		t.Error("Expected an error for a missing role ARN")
+	}
+	os.Unsetenv("AWS_ROLE_ARN")
+	os.Unsetenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if _, err := (AWSWebIdentityAuth{TokenPath: "/mock/token"}).GetConfig(); err == nil {
+		t.Error("Expected an error for a missing web identity role ARN")
+	}
+	if _, err := (AWSWebIdentityAuth{RoleARN: "arn:aws:iam::123456789012:role/mimir"}).GetConfig(); err == nil {
+		t.Error("Expected an error for a missing web identity token file")
+	}
+}
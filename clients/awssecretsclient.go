@@ -1,66 +1,231 @@
 package clients
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awsclient "github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 )
 
+// The retries of a request that AWS throttles, with the wait before each retry growing from the
+// minimum up to the maximum
+const (
+	awsMinBackoff = 500 * time.Millisecond
+	awsMaxBackoff = 30 * time.Second
+	awsMaxRetries = 5
+)
+
+// awsDefaultRawKey is the key that secrets which are not a JSON object of strings are loaded under
+const awsDefaultRawKey = "value"
+
+// awsVersionID matches the version IDs of AWS secrets, which are UUIDs, so a version selected by
+// tag or annotation can be told apart from a staging label
+var awsVersionID = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
 // awsSecretsClient holds the AWS Client needed for integration
 type awsSecretsClient struct {
-	Client *secretsmanager.SecretsManager
+	Client         *secretsmanager.SecretsManager
+	concurrency    int
+	previousSuffix string
+	rawKey         string
+}
+
+// AWSSecretsOption is an optional setting applied to the AWS Secrets Manager client
+type AWSSecretsOption func(client *awsSecretsClient)
+
+// WithAWSConcurrency limits how many secret values are fetched from AWS at once
+func WithAWSConcurrency(concurrency int) AWSSecretsOption {
+	return func(client *awsSecretsClient) {
+		if concurrency > 0 {
+			client.concurrency = concurrency
+		}
+	}
+}
+
+// WithAWSPreviousVersions adds the keys of the previous version of each secret, with the suffix
+// appended, so apps can accept both the old and new credentials during a rotation
+func WithAWSPreviousVersions(suffix string) AWSSecretsOption {
+	return func(client *awsSecretsClient) {
+		client.previousSuffix = suffix
+	}
+}
+
+// WithAWSRawKey sets the key that secrets which are not a JSON object of strings are loaded under
+func WithAWSRawKey(rawKey string) AWSSecretsOption {
+	return func(client *awsSecretsClient) {
+		if rawKey != "" {
+			client.rawKey = rawKey
+		}
+	}
 }
 
 // NewAWSSecretsClient provides a new SecretsManagerClient for using AWS secrets manager
-func NewAWSSecretsClient(auth AWSSecretsAuth) (SecretsManagerClient, error) {
+func NewAWSSecretsClient(auth AWSSecretsAuth, options ...AWSSecretsOption) (SecretsManagerClient, error) {
 	cfg, err := auth.GetConfig()
 	if err != nil {
 		return nil, err
 	}
-	sess, err := session.NewSession(cfg)
-	return &awsSecretsClient{
-		Client: secretsmanager.New(sess),
-	}, err
+	sess, err := session.NewSession(request.WithRetryer(cfg, newAWSRetryer()))
+	client := &awsSecretsClient{
+		Client:      secretsmanager.New(sess),
+		concurrency: 10,
+		rawKey:      awsDefaultRawKey,
+	}
+	for _, option := range options {
+		option(client)
+	}
+	return client, err
 }
 
 // GetSecrets will provide a slice of Secret type responses, for remote secrets located in AWS
 func (client awsSecretsClient) GetSecrets(namespaces ...string) ([]*Secret, error) {
+	awsSecrets, err := client.listManagedSecrets()
+	if err != nil {
+		return nil, err
+	}
+	return fetchAWSSecrets(awsSecrets, client.concurrency, client.getSecretData, namespaces...), nil
+}
+
+// newAWSRetryer provides the retryer of the AWS Secrets Manager client, which backs off from
+// requests that AWS throttles, so a sync of many secrets does not fail on the rate limit
+func newAWSRetryer() request.Retryer {
+	return awsclient.DefaultRetryer{
+		NumMaxRetries:    awsMaxRetries,
+		MinThrottleDelay: awsMinBackoff,
+		MaxThrottleDelay: awsMaxBackoff,
+	}
+}
+
+// newListManagedAWSSecretsInput provides the input listing the secrets tagged as managed by mimir.
+// Filtering on the tag key and value separately can still match a secret with another tag set to
+// true, so the tags of the listed secrets are checked again once they are returned
+func newListManagedAWSSecretsInput() *secretsmanager.ListSecretsInput {
+	return &secretsmanager.ListSecretsInput{
+		Filters: []*secretsmanager.Filter{
+			{Key: aws.String(secretsmanager.FilterNameStringTypeTagKey), Values: []*string{aws.String(Managed)}},
+			{Key: aws.String(secretsmanager.FilterNameStringTypeTagValue), Values: []*string{aws.String("true")}},
+		},
+		MaxResults: aws.Int64(100),
+	}
+}
+
+// listManagedSecrets lists the secrets tagged as managed by mimir, a page at a time
+func (client awsSecretsClient) listManagedSecrets() ([]*secretsmanager.SecretListEntry, error) {
 	awsSecrets := make([]*secretsmanager.SecretListEntry, 0)
-	secretsList, err := client.Client.ListSecrets(&secretsmanager.ListSecretsInput{})
+	err := client.Client.ListSecretsPages(newListManagedAWSSecretsInput(), func(page *secretsmanager.ListSecretsOutput, lastPage bool) bool {
+		awsSecrets = append(awsSecrets, page.SecretList...)
+		return true
+	})
+	return awsSecrets, err
+}
+
+// getSecretValue loads a version of a secret
+func (client awsSecretsClient) getSecretValue(secretName, version string) (*secretsmanager.GetSecretValueOutput, error) {
+	return client.Client.GetSecretValue(newAWSSecretValueInput(secretName, version))
+}
+
+// getSecretData loads the data of a version of a secret, along with the keys of the previous
+// version when they are asked for. Raw secrets are loaded under the raw key as they are
+func (client awsSecretsClient) getSecretData(secretName, version string, raw bool) (map[string]string, error) {
+	awsSecretValue, err := client.getSecretValue(secretName, version)
 	if err != nil {
 		return nil, err
 	}
-	awsSecrets = append(awsSecrets, secretsList.SecretList...)
-	for {
-		if secretsList.NextToken != nil {
-			secretsList, err = client.Client.ListSecrets(&secretsmanager.ListSecretsInput{NextToken: secretsList.NextToken})
-			if err != nil {
-				return nil, err
-			}
-			awsSecrets = append(awsSecrets, secretsList.SecretList...)
-		} else {
-			break
+	secretData, err := buildAWSSecretData(awsSecretValue, client.rawKey, raw)
+	if err != nil {
+		return nil, err
+	}
+	if client.previousSuffix == "" || version == "AWSPREVIOUS" {
+		return secretData, nil
+	}
+
+	previousValue, err := client.getSecretValue(secretName, "AWSPREVIOUS")
+	if err != nil {
+		// A secret that has never been rotated has no previous version
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != secretsmanager.ErrCodeResourceNotFoundException {
+			log.Printf("Failed to load the previous version of secret %s: %s\n", secretName, err.Error())
+		}
+		return secretData, nil
+	}
+	previousData, err := buildAWSSecretData(previousValue, client.rawKey, raw)
+	if err != nil {
+		log.Printf("Failed to read the previous version of secret %s: %s\n", secretName, err.Error())
+		return secretData, nil
+	}
+	addPreviousAWSSecretData(secretName, secretData, previousData, client.previousSuffix)
+	return secretData, nil
+}
+
+// newAWSSecretValueInput provides the input loading a version of a secret. The version is either a
+// version ID or a staging label, and the current version is loaded when it is empty
+func newAWSSecretValueInput(secretName, version string) *secretsmanager.GetSecretValueInput {
+	input := &secretsmanager.GetSecretValueInput{SecretId: aws.String(secretName)}
+	switch {
+	case version == "":
+		input.VersionStage = aws.String("AWSCURRENT")
+	case awsVersionID.MatchString(version):
+		input.VersionId = aws.String(version)
+	default:
+		input.VersionStage = aws.String(version)
+	}
+	return input
+}
+
+// addPreviousAWSSecretData adds the keys of the previous version of a secret to its data, with the
+// suffix appended. The reserved type key is left out, and keys that already exist are not replaced
+func addPreviousAWSSecretData(secretName string, data, previousData map[string]string, suffix string) {
+	for k, v := range previousData {
+		if k == Type {
+			continue
 		}
+		previousKey := k + suffix
+		if _, exists := data[previousKey]; exists {
+			log.Printf("Not adding the previous version of key %s to secret %s, the key %s already exists\n", k, secretName, previousKey)
+			continue
+		}
+		data[previousKey] = v
 	}
+}
 
+// fetchAWSSecrets loads the values of the managed secrets with a pool of workers, so no more than
+// the concurrency are requested from AWS at once
+func fetchAWSSecrets(awsSecrets []*secretsmanager.SecretListEntry, concurrency int, get func(string, string, bool) (map[string]string, error), namespaces ...string) []*Secret {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	jobs := make(chan *secretsmanager.SecretListEntry)
 	sc := make(chan *Secret)
 	wg := &sync.WaitGroup{}
-	for _, awsSecret := range awsSecrets {
-		managed, paths := isManagedAWSSecret(awsSecret.Tags)
-		if managed && paths != nil {
-			wg.Add(1)
-			go buildSecretFromAWSSecret(sc, wg, client.Client, *paths, *awsSecret.Name, namespaces...)
-		}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for awsSecret := range jobs {
+				_, paths := isManagedAWSSecret(awsSecret.Tags)
+				buildSecretFromAWSSecret(sc, get, *paths, *awsSecret.Name, awsSecret.Tags, namespaces...)
+			}
+		}()
 	}
 
 	go func() {
+		for _, awsSecret := range awsSecrets {
+			managed, paths := isManagedAWSSecret(awsSecret.Tags)
+			if managed && paths != nil && awsSecret.Name != nil {
+				jobs <- awsSecret
+			}
+		}
+		close(jobs)
 		wg.Wait()
 		close(sc)
 	}()
@@ -69,24 +234,27 @@ func (client awsSecretsClient) GetSecrets(namespaces ...string) ([]*Secret, erro
 	for secret := range sc {
 		secrets = append(secrets, secret)
 	}
-
-	return secrets, nil
+	return secrets
 }
 
-// GetSecret will retrieve a remote secret from AWS Secrets Manager
+// GetSecret will retrieve a remote secret from AWS Secrets Manager. A version ID or staging label
+// can be selected by adding it to the path after a #, eg. app#AWSPENDING. The raw mode and type
+// of the secret are read from its tags, as they are when syncing
 func (client awsSecretsClient) GetSecret(path string) (*Secret, error) {
-	awsSecretValue, err := client.Client.GetSecretValue(&secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(path),
-		VersionStage: aws.String("AWSCURRENT"),
-	})
+	secretName, version := path, ""
+	if idx := strings.LastIndex(path, "#"); idx >= 0 {
+		secretName, version = path[:idx], path[idx+1:]
+	}
+	description, err := client.Client.DescribeSecret(&secretsmanager.DescribeSecretInput{SecretId: aws.String(secretName)})
 	if err != nil {
 		return nil, err
 	}
-	secretData, err := buildAWSSecretData(awsSecretValue)
+	raw, _ := strconv.ParseBool(getAWSSecretTag(description.Tags, Raw))
+	secretData, err := client.getSecretData(secretName, version, raw)
 	if err != nil {
 		return nil, err
 	}
-	return &Secret{Name: path, Data: secretData}, nil
+	return &Secret{Name: secretName, Data: secretData, Type: getAWSSecretType(description.Tags)}, nil
 }
 
 // isManagedAWSSecret determines if an AWS secret is meant to be read by mimir
@@ -107,25 +275,35 @@ func isManagedAWSSecret(tags []*secretsmanager.Tag) (bool, *string) {
 	return managed, nil
 }
 
-// buildSecretFromAWSSecret calls AWS to get the value of a secret found to be managed by mimir
-func buildSecretFromAWSSecret(sc chan<- *Secret, wg *sync.WaitGroup, client *secretsmanager.SecretsManager, paths, secretName string, namespaces ...string) {
-	defer wg.Done()
-	awsSecretValue, err := client.GetSecretValue(&secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(secretName),
-		VersionStage: aws.String("AWSCURRENT"),
-	})
-	if err != nil {
-		log.Println(err.Error())
+// getAWSSecretType provides the k8s secret type set by tag on an AWS secret, if any
+func getAWSSecretType(tags []*secretsmanager.Tag) string {
+	return getAWSSecretTag(tags, Type)
+}
+
+// getAWSSecretTag provides the value of a tag on an AWS secret, if it is set
+func getAWSSecretTag(tags []*secretsmanager.Tag, key string) string {
+	for _, tag := range tags {
+		if tag.Key != nil && *tag.Key == key && tag.Value != nil {
+			return *tag.Value
+		}
 	}
-	buildSecretFromAWSSecretValue(sc, awsSecretValue, paths, namespaces...)
+	return ""
 }
 
-// buildSecretFromAWSSecretValue constructs a Secret type of response from an AWS secret value retrieved
-func buildSecretFromAWSSecretValue(sc chan<- *Secret, awsSecretValue *secretsmanager.GetSecretValueOutput, paths string, namespaces ...string) {
-	secretData, err := buildAWSSecretData(awsSecretValue)
+// buildSecretFromAWSSecret calls AWS to get the data of a secret found to be managed by mimir, with
+// the version, raw mode and type of the secret read from its tags
+func buildSecretFromAWSSecret(sc chan<- *Secret, get func(string, string, bool) (map[string]string, error), paths, secretName string, tags []*secretsmanager.Tag, namespaces ...string) {
+	raw, _ := strconv.ParseBool(getAWSSecretTag(tags, Raw))
+	secretData, err := get(secretName, getAWSSecretTag(tags, Version), raw)
 	if err != nil {
 		log.Println(err.Error())
+		return
 	}
+	buildSecretFromAWSSecretData(sc, secretData, paths, getAWSSecretType(tags), namespaces...)
+}
+
+// buildSecretFromAWSSecretData constructs a Secret type of response for each path the data of an AWS secret is loaded into
+func buildSecretFromAWSSecretData(sc chan<- *Secret, secretData map[string]string, paths, secretType string, namespaces ...string) {
 	splitPaths := strings.Split(paths, "+")
 	for _, splitPath := range splitPaths {
 		splitK8SPath := strings.Split(splitPath, "/")
@@ -142,27 +320,32 @@ func buildSecretFromAWSSecretValue(sc chan<- *Secret, awsSecretValue *secretsman
 				Name:      splitK8SPath[1],
 				Namespace: splitK8SPath[0],
 				Data:      secretData,
+				Type:      secretType,
 			}
 		}
 	}
 }
 
-// buildAWSSecretData converts the AWS secret data into a k8s friendly type for later use
-func buildAWSSecretData(awsSecret *secretsmanager.GetSecretValueOutput) (map[string]string, error) {
-	data := make(map[string]string)
-	if awsSecret.SecretString != nil {
-		secretBytes := []byte(*awsSecret.SecretString)
-		err := json.Unmarshal(secretBytes, &data)
-		return data, err
+// buildAWSSecretData converts the AWS secret data into a k8s friendly type for later use. A secret
+// that is a JSON object of strings is loaded key by key, and any other secret, or any secret when
+// raw is set, is loaded under the raw key as it is. The SDK has already decoded binary secrets, so
+// their bytes are kept exactly, with no further decoding
+func buildAWSSecretData(awsSecret *secretsmanager.GetSecretValueOutput, rawKey string, raw bool) (map[string]string, error) {
+	var secretBytes []byte
+	switch {
+	case awsSecret.SecretString != nil:
+		secretBytes = []byte(*awsSecret.SecretString)
+	case awsSecret.SecretBinary != nil:
+		secretBytes = awsSecret.SecretBinary
+	default:
+		return nil, fmt.Errorf("Could not find any secret data for %s", aws.StringValue(awsSecret.Name))
 	}
-	if awsSecret.SecretBinary != nil {
-		secretBytes := make([]byte, base64.StdEncoding.DecodedLen(len(awsSecret.SecretBinary)))
-		l, err := base64.StdEncoding.Decode(secretBytes, awsSecret.SecretBinary)
-		if err != nil {
-			return nil, err
+
+	if !raw {
+		data := make(map[string]string)
+		if err := json.Unmarshal(secretBytes, &data); err == nil {
+			return data, nil
 		}
-		err = json.Unmarshal(secretBytes[:l], &data)
-		return data, err
 	}
-	return nil, fmt.Errorf("Could not find any secret data for %s", *awsSecret.Name)
+	return map[string]string{rawKey: string(secretBytes)}, nil
 }
@@ -1,10 +1,18 @@
 package clients
 
 import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 )
 
@@ -29,11 +37,9 @@ func TestIsManagedAWSSecret(t *testing.T) {
 	}
 }
 
-func TestBuildSecretFromAWSSecretValue(t *testing.T) {
+func TestBuildSecretFromAWSSecretData(t *testing.T) {
 	sc := make(chan *Secret)
-	awsSecretValue := &secretsmanager.GetSecretValueOutput{
-		SecretString: aws.String("{\"mock\": \"mock\"}"),
-	}
+	secretData := map[string]string{"mock": "mock"}
 
 	results := make([]*Secret, 0)
 	wg := &sync.WaitGroup{}
@@ -45,12 +51,228 @@ func TestBuildSecretFromAWSSecretValue(t *testing.T) {
 		wg.Done()
 	}()
 
-	buildSecretFromAWSSecretValue(sc, awsSecretValue, "mockns1/mock1+mockns2/mock1", "mockns1", "mockns2")
+	buildSecretFromAWSSecretData(sc, secretData, "mockns1/mock1+mockns2/mock1+mockns3/mock1", "tls", "mockns1", "mockns2")
 	close(sc)
 
 	wg.Wait()
 
-	if len(results) < 2 {
-		t.Error("Did not get all expected secrets back")
+	if len(results) != 2 {
+		t.Error("Expected a secret for each path in the namespaces asked for")
+	}
+	for _, secret := range results {
+		if secret.Type != "tls" || secret.Data["mock"] != "mock" {
+			t.Error("Secret type and data were not carried onto the secret")
+		}
+	}
+}
+
+func TestGetAWSSecretType(t *testing.T) {
+	tags := []*secretsmanager.Tag{
+		&secretsmanager.Tag{Key: aws.String(Managed), Value: aws.String("true")},
+		&secretsmanager.Tag{Key: aws.String(Type), Value: aws.String("dockerconfigjson")},
+	}
+	if getAWSSecretType(tags) != "dockerconfigjson" {
+		t.Error("Secret type was not read from the tags")
+	}
+	if getAWSSecretType(tags[:1]) != "" {
+		t.Error("Expected no secret type without the tag")
+	}
+}
+
+func TestFetchAWSSecrets(t *testing.T) {
+	awsSecrets := make([]*secretsmanager.SecretListEntry, 0)
+	for _, name := range []string{"mock1", "mock2", "mock3", "mock4", "mock5"} {
+		awsSecrets = append(awsSecrets, &secretsmanager.SecretListEntry{
+			Name: aws.String(name),
+			Tags: []*secretsmanager.Tag{
+				&secretsmanager.Tag{Key: aws.String(Managed), Value: aws.String("true")},
+				&secretsmanager.Tag{Key: aws.String(Paths), Value: aws.String("mockns1/" + name)},
+			},
+		})
+	}
+	awsSecrets = append(awsSecrets, &secretsmanager.SecretListEntry{
+		Name: aws.String("unmanaged"),
+		Tags: []*secretsmanager.Tag{&secretsmanager.Tag{Key: aws.String("other"), Value: aws.String("true")}},
+	})
+
+	mu := &sync.Mutex{}
+	running, maxRunning := 0, 0
+	get := func(name, version string, raw bool) (map[string]string, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if name == "mock5" {
+			return nil, errors.New("mock failure")
+		}
+		return map[string]string{"mock": "mock"}, nil
+	}
+
+	secrets := fetchAWSSecrets(awsSecrets, 2, get, "mockns1")
+	if len(secrets) != 4 {
+		t.Errorf("Expected 4 secrets, got %d", len(secrets))
+	}
+	if maxRunning > 2 {
+		t.Errorf("Expected at most 2 secrets to be fetched at once, got %d", maxRunning)
+	}
+}
+
+func TestNewAWSRetryer(t *testing.T) {
+	retryer := newAWSRetryer()
+	if retryer.MaxRetries() != awsMaxRetries {
+		t.Errorf("Expected %d retries, got %d", awsMaxRetries, retryer.MaxRetries())
+	}
+
+	throttled := &request.Request{
+		Error:        awserr.New("ThrottlingException", "Rate exceeded", nil),
+		HTTPResponse: &http.Response{StatusCode: http.StatusBadRequest, Header: http.Header{}},
+	}
+	if !retryer.ShouldRetry(throttled) {
+		t.Error("Expected a throttled request to be retried")
+	}
+	if delay := retryer.RetryRules(throttled); delay < awsMinBackoff || delay > awsMaxBackoff {
+		t.Errorf("Expected the throttle delay to be between %s and %s, got %s", awsMinBackoff, awsMaxBackoff, delay)
+	}
+}
+
+func TestListManagedAWSSecretsInput(t *testing.T) {
+	input := newListManagedAWSSecretsInput()
+	if err := input.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(input.Filters) != 2 ||
+		*input.Filters[0].Key != "tag-key" || *input.Filters[0].Values[0] != Managed ||
+		*input.Filters[1].Key != "tag-value" || *input.Filters[1].Values[0] != "true" {
+		t.Errorf("Unexpected filters %v", input.Filters)
+	}
+}
+
+func TestNewAWSSecretValueInput(t *testing.T) {
+	input := newAWSSecretValueInput("mock", "")
+	if *input.VersionStage != "AWSCURRENT" || input.VersionId != nil {
+		t.Errorf("Expected the current version by default, got %+v", input)
+	}
+	input = newAWSSecretValueInput("mock", "AWSPENDING")
+	if *input.VersionStage != "AWSPENDING" || input.VersionId != nil {
+		t.Errorf("Expected the pending stage, got %+v", input)
+	}
+	input = newAWSSecretValueInput("mock", "EXAMPLE1-90ab-cdef-fedc-ba987SECRET1")
+	if input.VersionStage == nil || input.VersionId != nil {
+		t.Errorf("Expected a label that is not a UUID to be used as a stage, got %+v", input)
+	}
+	input = newAWSSecretValueInput("mock", "a1b2c3d4-5678-90ab-cdef-1234567890ab")
+	if input.VersionStage != nil || *input.VersionId != "a1b2c3d4-5678-90ab-cdef-1234567890ab" {
+		t.Errorf("Expected a version ID, got %+v", input)
+	}
+}
+
+func TestAddPreviousAWSSecretData(t *testing.T) {
+	data := map[string]string{"password": "new", "user_PREVIOUS": "kept"}
+	addPreviousAWSSecretData("mock", data, map[string]string{"password": "old", "user": "mimir", Type: "tls"}, "_PREVIOUS")
+	if data["password"] != "new" || data["password_PREVIOUS"] != "old" {
+		t.Errorf("Expected both the current and previous password, got %v", data)
+	}
+	if data["user_PREVIOUS"] != "kept" {
+		t.Error("Expected an existing key not to be replaced by the previous version")
+	}
+	if _, ok := data[Type+"_PREVIOUS"]; ok {
+		t.Error("Expected the type key of the previous version to be left out")
+	}
+}
+
+func TestBuildAWSSecretData(t *testing.T) {
+	data, err := buildAWSSecretData(&secretsmanager.GetSecretValueOutput{SecretString: aws.String("{\"user\": \"mimir\"}")}, "value", false)
+	if err != nil || data["user"] != "mimir" || len(data) != 1 {
+		t.Errorf("Expected a JSON object to be loaded by key, got %v", data)
+	}
+
+	data, err = buildAWSSecretData(&secretsmanager.GetSecretValueOutput{SecretString: aws.String("hunter2")}, "password", false)
+	if err != nil || data["password"] != "hunter2" || len(data) != 1 {
+		t.Errorf("Expected plain text under the raw key, got %v", data)
+	}
+
+	data, err = buildAWSSecretData(&secretsmanager.GetSecretValueOutput{SecretString: aws.String("{\"user\": \"mimir\"}")}, "value", true)
+	if err != nil || data["value"] != "{\"user\": \"mimir\"}" {
+		t.Errorf("Expected raw mode to keep the JSON as it is, got %v", data)
+	}
+
+	binary := []byte{0x30, 0x82, 0x00, 0xff, 0xfe}
+	data, err = buildAWSSecretData(&secretsmanager.GetSecretValueOutput{SecretBinary: binary}, "cert.der", false)
+	if err != nil || data["cert.der"] != string(binary) {
+		t.Errorf("Expected the binary to be kept byte for byte, got %v", []byte(data["cert.der"]))
+	}
+
+	data, err = buildAWSSecretData(&secretsmanager.GetSecretValueOutput{SecretBinary: []byte("{\"user\": \"mimir\"}")}, "value", false)
+	if err != nil || data["user"] != "mimir" {
+		t.Errorf("Expected a binary JSON object to be loaded by key, got %v", data)
+	}
+
+	if _, err := buildAWSSecretData(&secretsmanager.GetSecretValueOutput{Name: aws.String("mock")}, "value", false); err == nil {
+		t.Error("Expected an error for a secret with no data")
+	}
+}
+
+func TestBuildSecretFromAWSSecretRawTag(t *testing.T) {
+	sc := make(chan *Secret, 1)
+	var gotRaw bool
+	var gotVersion string
+	get := func(name, version string, raw bool) (map[string]string, error) {
+		gotVersion, gotRaw = version, raw
+		return map[string]string{"value": "mock"}, nil
+	}
+	tags := []*secretsmanager.Tag{
+		&secretsmanager.Tag{Key: aws.String(Raw), Value: aws.String("true")},
+		&secretsmanager.Tag{Key: aws.String(Version), Value: aws.String("AWSPENDING")},
+	}
+	buildSecretFromAWSSecret(sc, get, "mockns1/mock1", "mock", tags, "mockns1")
+	close(sc)
+
+	if !gotRaw || gotVersion != "AWSPENDING" {
+		t.Errorf("Expected the raw and version tags to be used, got raw %t and version %s", gotRaw, gotVersion)
+	}
+	if secret := <-sc; secret == nil || secret.Name != "mock1" {
+		t.Error("Expected the secret to be built")
+	}
+}
+
+func TestGetAWSSecretTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "secretsmanager.DescribeSecret":
+			w.Write([]byte(`{"Name":"bundle","Tags":[{"Key":"mimir-raw","Value":"true"},{"Key":"mimir-type","Value":"tls"}]}`))
+		case "secretsmanager.GetSecretValue":
+			w.Write([]byte(`{"Name":"bundle","SecretString":"{\"tls.crt\":\"cert\"}"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("eu-west-1"),
+		Credentials: credentials.NewStaticCredentials("mockid", "mocksecret", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := awsSecretsClient{Client: secretsmanager.New(sess), rawKey: awsDefaultRawKey}
+
+	secret, err := client.GetSecret("bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["value"] != `{"tls.crt":"cert"}` || len(secret.Data) != 1 {
+		t.Errorf("Expected the secret to be loaded raw from its tag, got %v", secret.Data)
+	}
+	if secret.Type != "tls" {
+		t.Errorf("Expected the type to be read from the tags, got %s", secret.Type)
 	}
 }
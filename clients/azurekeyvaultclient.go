@@ -98,6 +98,7 @@ func (client azureSecretsClient) GetSecrets(namespaces ...string) ([]*Secret, er
 								Name:      splitPath[1],
 								Namespace: splitPath[0],
 								Data:      secret.Data,
+								Type:      getAzureVaultType(vault.Tags),
 							})
 						}
 					}
@@ -156,6 +157,7 @@ func (client azureSecretsClient) GetSecret(path string) (*Secret, error) {
 		return nil, err
 	}
 	secret.Name = path
+	secret.Type = getAzureVaultType(r.Tags)
 
 	return secret, nil
 }
@@ -189,6 +191,14 @@ func (client azureSecretsClient) loadSecret(vaultURI string, secrets keyvault.Se
 	return &result, nil
 }
 
+// getAzureVaultType provides the k8s secret type set by tag on an Azure Key Vault, if any
+func getAzureVaultType(tags map[string]*string) string {
+	if value, ok := tags[Type]; ok && value != nil {
+		return *value
+	}
+	return ""
+}
+
 func isManagedVault(tags map[string]*string) (bool, *string) {
 	managed := false
 	var paths *string
@@ -0,0 +1,149 @@
+package clients
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// refreshingClient wraps a SecretsManagerClient, replacing it in the background with a freshly
+// authenticated client, so a long running process does not use expired credentials
+type refreshingClient struct {
+	mu     sync.RWMutex
+	client *inflightClient
+	load   func() (SecretsManagerClient, error)
+}
+
+// inflightClient is a loaded client along with the calls still being made to it, so it is only
+// closed once they have finished
+type inflightClient struct {
+	SecretsManagerClient
+	calls sync.WaitGroup
+}
+
+// NewRefreshingClient wraps a SecretsManagerClient so that it is reloaded every interval. If a
+// reload fails, the existing client is kept and the reload is tried again on the next interval
+func NewRefreshingClient(client SecretsManagerClient, load func() (SecretsManagerClient, error), interval time.Duration) SecretsManagerClient {
+	rc := &refreshingClient{client: &inflightClient{SecretsManagerClient: client}, load: load}
+	go func() {
+		for {
+			time.Sleep(interval)
+			rc.refresh()
+		}
+	}()
+	return rc
+}
+
+// refresh reloads the wrapped client, keeping the existing client on failure. The replaced client
+// is closed in the background once the calls already made to it have finished
+func (client *refreshingClient) refresh() {
+	fresh, err := client.load()
+	if err != nil {
+		log.Printf("Failed to refresh the secrets manager client, keeping the existing client: %s\n", err.Error())
+		return
+	}
+	client.mu.Lock()
+	stale := client.client
+	client.client = &inflightClient{SecretsManagerClient: fresh}
+	client.mu.Unlock()
+	go func() {
+		stale.calls.Wait()
+		closeClient(stale.SecretsManagerClient)
+	}()
+}
+
+// acquire provides the most recently loaded client, counting a call to it that the caller must
+// mark as done
+func (client *refreshingClient) acquire() *inflightClient {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	client.client.calls.Add(1)
+	return client.client
+}
+
+// GetSecrets calls the most recently loaded client
+func (client *refreshingClient) GetSecrets(namespaces ...string) ([]*Secret, error) {
+	current := client.acquire()
+	defer current.calls.Done()
+	return current.GetSecrets(namespaces...)
+}
+
+// GetSecret calls the most recently loaded client
+func (client *refreshingClient) GetSecret(path string) (*Secret, error) {
+	current := client.acquire()
+	defer current.calls.Done()
+	return current.GetSecret(path)
+}
+
+// cachedSecret is a secret held by the cache, along with when it should no longer be used
+type cachedSecret struct {
+	secret  *Secret
+	expires time.Time
+}
+
+// cachingClient wraps a SecretsManagerClient, holding the result of each GetSecret for a time to
+// live, so repeated lookups of the same secret do not all reach the backend
+type cachingClient struct {
+	SecretsManagerClient
+	mu      sync.Mutex
+	ttl     time.Duration
+	secrets map[string]cachedSecret
+	now     func() time.Time
+}
+
+// NewCachingClient wraps a SecretsManagerClient so that secrets loaded by GetSecret are cached for
+// the ttl. Errors are never cached, and GetSecrets always calls the wrapped client
+func NewCachingClient(client SecretsManagerClient, ttl time.Duration) SecretsManagerClient {
+	return &cachingClient{
+		SecretsManagerClient: client,
+		ttl:                  ttl,
+		secrets:              make(map[string]cachedSecret),
+		now:                  time.Now,
+	}
+}
+
+// GetSecret provides the cached secret for the path if it has not expired, otherwise it calls
+// the wrapped client and caches the result
+func (client *cachingClient) GetSecret(path string) (*Secret, error) {
+	now := client.now()
+
+	client.mu.Lock()
+	cached, ok := client.secrets[path]
+	client.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		cacheLookups.WithLabelValues("hit").Inc()
+		return copySecret(cached.secret), nil
+	}
+	cacheLookups.WithLabelValues("miss").Inc()
+
+	secret, err := client.SecretsManagerClient.GetSecret(path)
+	if err != nil {
+		return nil, err
+	}
+
+	client.mu.Lock()
+	// Expired secrets are dropped as new ones are added, so the cache only holds recent lookups
+	for k, v := range client.secrets {
+		if !now.Before(v.expires) {
+			delete(client.secrets, k)
+		}
+	}
+	client.secrets[path] = cachedSecret{secret: copySecret(secret), expires: now.Add(client.ttl)}
+	client.mu.Unlock()
+
+	return secret, nil
+}
+
+// copySecret provides a copy of a secret, so that a caller changing its data does not change
+// the secret held by the cache
+func copySecret(secret *Secret) *Secret {
+	if secret == nil {
+		return nil
+	}
+	cp := *secret
+	cp.Data = make(map[string]string)
+	for k, v := range secret.Data {
+		cp.Data[k] = v
+	}
+	return &cp
+}
@@ -0,0 +1,96 @@
+package clients
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type countingSecretsManagerClient struct {
+	calls  int
+	err    error
+	closed chan struct{}
+}
+
+func (client *countingSecretsManagerClient) Close() {
+	close(client.closed)
+}
+
+func (client *countingSecretsManagerClient) GetSecrets(namespaces ...string) ([]*Secret, error) {
+	client.calls++
+	return []*Secret{}, client.err
+}
+
+func (client *countingSecretsManagerClient) GetSecret(path string) (*Secret, error) {
+	client.calls++
+	if client.err != nil {
+		return nil, client.err
+	}
+	return &Secret{Name: path, Data: map[string]string{"key": "mock"}}, nil
+}
+
+func TestCachingClient(t *testing.T) {
+	now := time.Now()
+	backend := &countingSecretsManagerClient{}
+	client := NewCachingClient(backend, time.Minute).(*cachingClient)
+	client.now = func() time.Time { return now }
+
+	secret, err := client.GetSecret("mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.Data["key"] = "changed"
+	secret, _ = client.GetSecret("mock")
+	if backend.calls != 1 {
+		t.Errorf("Expected 1 call to the backend within the ttl, got %d", backend.calls)
+	}
+	if secret.Data["key"] != "mock" {
+		t.Error("Changing a returned secret should not change the cache")
+	}
+
+	now = now.Add(time.Minute)
+	client.GetSecret("mock")
+	if backend.calls != 2 {
+		t.Errorf("Expected the backend to be called once the ttl passed, got %d calls", backend.calls)
+	}
+
+	backend.err = errors.New("mock")
+	client.GetSecret("failing")
+	client.GetSecret("failing")
+	if backend.calls != 4 {
+		t.Errorf("Errors should not be cached, got %d calls", backend.calls)
+	}
+}
+
+func TestRefreshingClient(t *testing.T) {
+	stale := &countingSecretsManagerClient{closed: make(chan struct{})}
+	client := &refreshingClient{client: &inflightClient{SecretsManagerClient: stale}}
+
+	client.load = func() (SecretsManagerClient, error) { return nil, errors.New("mock") }
+	client.refresh()
+	if _, err := client.GetSecret("mock"); err != nil {
+		t.Error("Existing client should be kept when a refresh fails")
+	}
+
+	// A call still being made to the stale client holds off closing it
+	inflight := client.acquire()
+	fresh := &countingSecretsManagerClient{}
+	client.load = func() (SecretsManagerClient, error) { return fresh, nil }
+	client.refresh()
+	client.GetSecret("mock")
+	if fresh.calls != 1 {
+		t.Error("Refreshed client should be used")
+	}
+	select {
+	case <-stale.closed:
+		t.Error("Replaced client should not be closed while a call to it is in flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	inflight.calls.Done()
+	select {
+	case <-stale.closed:
+	case <-time.After(time.Second):
+		t.Error("Replaced client should be closed once its calls have finished")
+	}
+}
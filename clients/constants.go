@@ -11,13 +11,15 @@ const (
 	// AWS denotes the secret was managed by AWS
 	// secrets manager
 	AWS SecretsManager = "aws"
+	// AWSParameterStore denotes the secret was managed
+	// by AWS Systems Manager Parameter Store
+	AWSParameterStore SecretsManager = "aws-ssm"
 	// Azure denotes the secret was managed by Azure
 	// Key Vault
 	// TODO - Implement Azure Key Vault solution
 	Azure SecretsManager = "azure"
 	// GCP denotes the secret was managed by GCP
-	// TODO - Implement a secrets management
-	// solution for GCP, KMS?
+	// Secret Manager
 	GCP SecretsManager = "gcp"
 	// Managed is the common tag/annotation denoting
 	// that the secret is managed by mimir
@@ -28,10 +30,43 @@ const (
 	// Source is the common annotation to denote
 	// where the secret was sourced from in k8s
 	Source string = "mimir-source"
+	// Hash is the common annotation holding a hash of
+	// the content of a secret managed in k8s, so that
+	// unchanged secrets are not needlessly rewritten
+	Hash string = "mimir-hash"
+	// Checksum is the pod template annotation patched
+	// onto workloads to trigger a rolling restart when
+	// a secret they consume is updated
+	Checksum string = "mimir-checksum"
+	// Reload is a workload annotation listing the names
+	// of secrets, comma separated, that should trigger
+	// a rolling restart of the workload when updated
+	Reload string = "mimir-reload"
+	// Owner is the annotation on a secret naming the
+	// MimirSecret resource it is reconciled from. These
+	// secrets are never marked as managed, so a sync
+	// leaves them alone
+	Owner string = "mimir-owner"
+	// Type is the common tag/annotation, or reserved
+	// key in the secret data, selecting the type of
+	// the secret in k8s, eg. tls or dockerconfigjson
+	Type string = "mimir-type"
+	// Version is the common tag/annotation selecting the
+	// version of an AWS secret to load, either a staging
+	// label such as AWSPENDING, or a version ID
+	Version string = "mimir-version"
+	// Raw is the common tag that, when set to true, loads
+	// an AWS secret under a single key as it is, rather
+	// than as a JSON object of keys
+	Raw string = "mimir-raw"
 	// Hook is a reference string per server that
 	// allows multiple hooks to co-exist in the
-	// same cluster
+	// same cluster. It is also the label on the
+	// secrets generated by the hook
 	Hook string = "mimir-hook"
+	// Pod is the label on a secret generated by the
+	// webhook naming the pod it was generated for
+	Pod string = "mimir-pod"
 	// Remote is the path/name of the remote secret
 	Remote string = "mimir-remote"
 	// Local is an override. When set, the secret will
@@ -45,4 +80,25 @@ const (
 	// patch the pod to inject all the keys of the
 	// secret to the containers as environment vars
 	Env string = "mimir-env"
+	// EnvKeys limits the keys of the secret injected
+	// as env vars to a comma separated list, where
+	// each key can be renamed as key=NAME
+	EnvKeys string = "mimir-env-keys"
+	// EnvPrefix is added to the name of every env var
+	// injected that has not been renamed
+	EnvPrefix string = "mimir-env-prefix"
+	// EnvSanitize is a switch that when set, replaces
+	// characters that are not valid in env var names
+	// with an underscore
+	EnvSanitize string = "mimir-env-sanitize"
+	// Containers limits injection to the containers
+	// named in a comma separated list
+	Containers string = "mimir-containers"
+	// ExcludeContainers stops injection into the
+	// containers named in a comma separated list
+	ExcludeContainers string = "mimir-exclude-containers"
+	// Secrets is the annotation patched onto a pod
+	// listing the names of the secrets generated for
+	// it, comma separated, to remove when it is deleted
+	Secrets string = "mimir-secrets"
 )
@@ -0,0 +1,139 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+const (
+	// gcpScope is the oauth2 scope requested for access to GCP Secret Manager
+	gcpScope = "https://www.googleapis.com/auth/cloud-platform"
+	// gcpTokenURL is the default token endpoint for service account keys
+	gcpTokenURL = "https://oauth2.googleapis.com/token"
+	// gcpMetadataHost is the default host of the GCE metadata server
+	gcpMetadataHost = "metadata.google.internal"
+)
+
+// GCPSecretsAuth interface provides a common function set to authenticate with GCP from mimir
+type GCPSecretsAuth interface {
+	GetTokenSource(ctx context.Context) (oauth2.TokenSource, error)
+}
+
+// GCPServiceAccountFileAuth contains auth information for using a service account key file to authenticate
+type GCPServiceAccountFileAuth struct {
+	Path string
+}
+
+// gcpServiceAccountKey is the subset of a service account key file needed to request tokens
+type gcpServiceAccountKey struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// GetTokenSource loads a token source from a service account key file, falling back to the
+// GOOGLE_APPLICATION_CREDENTIALS environment variable if no path is set
+func (auth GCPServiceAccountFileAuth) GetTokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	path := auth.Path
+	if path == "" {
+		path = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if path == "" {
+		return nil, errors.New("No service account file path set, and GOOGLE_APPLICATION_CREDENTIALS environment variable not set")
+	}
+	keyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var key gcpServiceAccountKey
+	if err := json.Unmarshal(keyBytes, &key); err != nil {
+		return nil, err
+	}
+	if key.Type != "service_account" {
+		return nil, fmt.Errorf("Expected a service_account key file, got %s", key.Type)
+	}
+	tokenURL := key.TokenURI
+	if tokenURL == "" {
+		tokenURL = gcpTokenURL
+	}
+	cfg := &jwt.Config{
+		Email:        key.ClientEmail,
+		PrivateKey:   []byte(key.PrivateKey),
+		PrivateKeyID: key.PrivateKeyID,
+		Scopes:       []string{gcpScope},
+		TokenURL:     tokenURL,
+	}
+	return cfg.TokenSource(ctx), nil
+}
+
+// GCPMetadataAuth contains auth information for using the default service account of the
+// GCE/GKE metadata server to authenticate
+type GCPMetadataAuth struct {
+	Host string
+}
+
+// GetTokenSource loads a token source backed by the metadata server. The host can be overridden,
+// otherwise it is taken from the GCE_METADATA_HOST environment variable or the GCP default
+func (auth GCPMetadataAuth) GetTokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	host := auth.Host
+	if host == "" {
+		host = os.Getenv("GCE_METADATA_HOST")
+	}
+	if host == "" {
+		host = gcpMetadataHost
+	}
+	return oauth2.ReuseTokenSource(nil, gcpMetadataTokenSource{
+		URL:    fmt.Sprintf("http://%s/computeMetadata/v1/instance/service-accounts/default/token", host),
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}), nil
+}
+
+// gcpMetadataTokenSource requests access tokens from the metadata server
+type gcpMetadataTokenSource struct {
+	URL    string
+	Client *http.Client
+}
+
+// Token requests a new access token from the metadata server
+func (ts gcpMetadataTokenSource) Token() (*oauth2.Token, error) {
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := ts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Metadata server returned %s when requesting a token", resp.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("Metadata server did not return an access token")
+	}
+	return &oauth2.Token{
+		AccessToken: token.AccessToken,
+		TokenType:   token.TokenType,
+		Expiry:      time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}, nil
+}
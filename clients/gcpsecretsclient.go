@@ -0,0 +1,251 @@
+package clients
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// gcpSecretManagerURL is the base URL of the GCP Secret Manager REST API
+const gcpSecretManagerURL = "https://secretmanager.googleapis.com/v1"
+
+// gcpConcurrency limits how many secret versions are accessed from GCP at once
+const gcpConcurrency = 10
+
+// gcpSecretsClient holds the http client and project needed for integration with GCP Secret Manager
+type gcpSecretsClient struct {
+	Client  *http.Client
+	BaseURL string
+	Project string
+}
+
+// gcpSecret is a secret as listed by GCP Secret Manager, without any of its data
+type gcpSecret struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// gcpSecretList is a single page of secrets listed from GCP Secret Manager
+type gcpSecretList struct {
+	Secrets       []gcpSecret `json:"secrets"`
+	NextPageToken string      `json:"nextPageToken"`
+}
+
+// gcpSecretVersion is the accessed data of a secret version in GCP Secret Manager
+type gcpSecretVersion struct {
+	Name    string `json:"name"`
+	Payload struct {
+		Data string `json:"data"`
+	} `json:"payload"`
+}
+
+// NewGCPSecretsClient provides a new SecretsManagerClient for using GCP Secret Manager
+func NewGCPSecretsClient(auth GCPSecretsAuth, project ...string) (SecretsManagerClient, error) {
+	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
+	if len(project) > 0 && project[0] != "" {
+		projectID = project[0]
+	}
+
+	if projectID == "" {
+		return nil, errors.New("Failed to find a valid project ID for GCP")
+	}
+
+	ts, err := auth.GetTokenSource(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcpSecretsClient{
+		Client:  oauth2.NewClient(context.Background(), ts),
+		BaseURL: gcpSecretManagerURL,
+		Project: projectID,
+	}, nil
+}
+
+// GetSecrets will provide a slice of Secret type responses, for remote secrets located in GCP.
+// Only the secrets labelled as managed by mimir are listed
+func (client gcpSecretsClient) GetSecrets(namespaces ...string) ([]*Secret, error) {
+	gcpSecrets := make([]gcpSecret, 0)
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("filter", fmt.Sprintf("labels.%s=true", Managed))
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		var secretsList gcpSecretList
+		if err := client.get(fmt.Sprintf("projects/%s/secrets?%s", client.Project, query.Encode()), &secretsList); err != nil {
+			return nil, err
+		}
+		gcpSecrets = append(gcpSecrets, secretsList.Secrets...)
+		if secretsList.NextPageToken == "" {
+			break
+		}
+		pageToken = secretsList.NextPageToken
+	}
+	return fetchGCPSecrets(gcpSecrets, gcpConcurrency, client.accessSecret, namespaces...), nil
+}
+
+// fetchGCPSecrets loads the data of the managed secrets with a pool of workers, so no more than
+// the concurrency are accessed from GCP at once
+func fetchGCPSecrets(gcpSecrets []gcpSecret, concurrency int, access func(string) (map[string]string, error), namespaces ...string) []*Secret {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	jobs := make(chan gcpSecret)
+	sc := make(chan *Secret)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for gcpSecret := range jobs {
+				_, paths := isManagedGCPSecret(gcpSecret.Labels, gcpSecret.Annotations)
+				buildSecretFromGCPSecret(sc, access, *paths, gcpSecret.Name, getGCPSecretType(gcpSecret.Labels, gcpSecret.Annotations), namespaces...)
+			}
+		}()
+	}
+
+	go func() {
+		for _, gcpSecret := range gcpSecrets {
+			managed, paths := isManagedGCPSecret(gcpSecret.Labels, gcpSecret.Annotations)
+			if managed && paths != nil {
+				jobs <- gcpSecret
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(sc)
+	}()
+
+	secrets := make([]*Secret, 0)
+	for secret := range sc {
+		secrets = append(secrets, secret)
+	}
+	return secrets
+}
+
+// GetSecret will retrieve the latest version of a remote secret from GCP Secret Manager. The
+// path can either be the ID of a secret in the configured project, or its full resource name.
+// The type of the secret is read from its labels and annotations, as it is when syncing
+func (client gcpSecretsClient) GetSecret(path string) (*Secret, error) {
+	name := path
+	if !strings.HasPrefix(name, "projects/") {
+		name = fmt.Sprintf("projects/%s/secrets/%s", client.Project, path)
+	}
+	var metadata gcpSecret
+	if err := client.get(name, &metadata); err != nil {
+		return nil, err
+	}
+	secretData, err := client.accessSecret(name)
+	if err != nil {
+		return nil, err
+	}
+	splitName := strings.Split(name, "/")
+	return &Secret{Name: splitName[len(splitName)-1], Data: secretData, Type: getGCPSecretType(metadata.Labels, metadata.Annotations)}, nil
+}
+
+// buildSecretFromGCPSecret calls GCP to get the value of a secret found to be managed by mimir,
+// and sends a Secret for each of its paths that match a namespace
+func buildSecretFromGCPSecret(sc chan<- *Secret, access func(string) (map[string]string, error), paths, name, secretType string, namespaces ...string) {
+	secretData, err := access(name)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	for _, path := range strings.Split(paths, "+") {
+		splitPath := strings.Split(path, "/")
+		if len(splitPath) != 2 {
+			log.Printf("Skipping invalid path %s for GCP secret %s\n", path, name)
+			continue
+		}
+		for _, namespace := range namespaces {
+			if namespace == splitPath[0] {
+				sc <- &Secret{
+					Name:      splitPath[1],
+					Namespace: splitPath[0],
+					Data:      secretData,
+					Type:      secretType,
+				}
+				break
+			}
+		}
+	}
+}
+
+// accessSecret loads the data of the latest version of a secret by its full resource name
+func (client gcpSecretsClient) accessSecret(name string) (map[string]string, error) {
+	var version gcpSecretVersion
+	if err := client.get(fmt.Sprintf("%s/versions/latest:access", name), &version); err != nil {
+		return nil, err
+	}
+	return buildGCPSecretData(name, version)
+}
+
+// get performs a GET against the GCP Secret Manager API, decoding the json response into result
+func (client gcpSecretsClient) get(path string, result interface{}) error {
+	resp, err := client.Client.Get(fmt.Sprintf("%s/%s", client.BaseURL, path))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("GCP Secret Manager returned %s for %s: %s", resp.Status, path, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// isManagedGCPSecret determines if a GCP secret is meant to be read by mimir. GCP label values can
+// not hold the / and + characters, so paths are preferably read from the annotation, but can be
+// set as a label using _ between the namespace and secret, and __ between each path
+func isManagedGCPSecret(labels, annotations map[string]string) (bool, *string) {
+	managed := labels[Managed] == "true"
+	if paths, ok := annotations[Paths]; ok && paths != "" {
+		return managed, &paths
+	}
+	if labelPaths, ok := labels[Paths]; ok && labelPaths != "" {
+		splitPaths := strings.Split(labelPaths, "__")
+		for idx, splitPath := range splitPaths {
+			splitPaths[idx] = strings.Replace(splitPath, "_", "/", 1)
+		}
+		paths := strings.Join(splitPaths, "+")
+		return managed, &paths
+	}
+	return managed, nil
+}
+
+// getGCPSecretType provides the k8s secret type set on a GCP secret, if any. Full type names can
+// only be held in an annotation, while labels are limited to the aliases, such as tls
+func getGCPSecretType(labels, annotations map[string]string) string {
+	if secretType, ok := annotations[Type]; ok && secretType != "" {
+		return secretType
+	}
+	return labels[Type]
+}
+
+// buildGCPSecretData converts the GCP secret payload into a k8s friendly type for later use
+func buildGCPSecretData(name string, version gcpSecretVersion) (map[string]string, error) {
+	if version.Payload.Data == "" {
+		return nil, fmt.Errorf("Could not find any secret data for %s", name)
+	}
+	secretBytes, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]string)
+	err = json.Unmarshal(secretBytes, &data)
+	return data, err
+}
@@ -0,0 +1,170 @@
+package clients
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+func mockGCPServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/projects/mock/secrets":
+			if r.URL.Query().Get("filter") != "labels.mimir-managed=true" {
+				t.Errorf("Expected the list to be filtered on the managed label, got %s", r.URL.Query().Get("filter"))
+			}
+			json.NewEncoder(w).Encode(gcpSecretList{
+				Secrets: []gcpSecret{
+					gcpSecret{
+						Name:        "projects/mock/secrets/mock",
+						Labels:      map[string]string{Managed: "true"},
+						Annotations: map[string]string{Paths: "mock/mock+mock1/mock1+mock2/mock2"},
+					},
+					gcpSecret{
+						Name:   "projects/mock/secrets/unmanaged",
+						Labels: map[string]string{Paths: "mock_unmanaged"},
+					},
+				},
+			})
+		case "/projects/mock/secrets/mock":
+			json.NewEncoder(w).Encode(gcpSecret{
+				Name:        "projects/mock/secrets/mock",
+				Labels:      map[string]string{Managed: "true", Type: "tls"},
+				Annotations: map[string]string{Type: string(core_v1.SecretTypeBasicAuth)},
+			})
+		case "/projects/mock/secrets/mock/versions/latest:access":
+			version := gcpSecretVersion{Name: "projects/mock/secrets/mock/versions/1"}
+			version.Payload.Data = base64.StdEncoding.EncodeToString([]byte("{\"mock\": \"mock\"}"))
+			json.NewEncoder(w).Encode(version)
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestGCPGetSecret(t *testing.T) {
+	server := mockGCPServer(t)
+	defer server.Close()
+
+	client := gcpSecretsClient{Client: server.Client(), BaseURL: server.URL, Project: "mock"}
+
+	secret, err := client.GetSecret("mock")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if secret.Name != "mock" {
+		t.Error("Did not load with expected secret name")
+	}
+
+	if value, ok := secret.Data["mock"]; !ok || value != "mock" {
+		t.Error("Data value not as expected")
+	}
+
+	if secret.Type != string(core_v1.SecretTypeBasicAuth) {
+		t.Errorf("Expected the type to be read from the annotation, got %s", secret.Type)
+	}
+}
+
+func TestGCPGetSecrets(t *testing.T) {
+	server := mockGCPServer(t)
+	defer server.Close()
+
+	client := gcpSecretsClient{Client: server.Client(), BaseURL: server.URL, Project: "mock"}
+
+	secrets, err := client.GetSecrets("mock", "mock1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(secrets) != 2 {
+		t.Errorf("Expected 2 secrets, got %d", len(secrets))
+	}
+
+	for _, secret := range secrets {
+		if secret.Name != secret.Namespace {
+			t.Error("Name or namespace of the secret isn't right")
+		}
+		if value, ok := secret.Data["mock"]; !ok || value != "mock" {
+			t.Error("Data value not as expected")
+		}
+	}
+}
+
+func TestIsManagedGCPSecret(t *testing.T) {
+	managed, paths := isManagedGCPSecret(map[string]string{
+		Managed: "true",
+		Paths:   "mockns1_mocksec1__mockns2_mocksec1",
+	}, nil)
+	if !managed || paths == nil {
+		t.Fatal("Did not come back as fully managed")
+	}
+	if *paths != "mockns1/mocksec1+mockns2/mocksec1" {
+		t.Errorf("Label paths were not converted as expected, got %s", *paths)
+	}
+
+	managed, paths = isManagedGCPSecret(map[string]string{Managed: "true"}, nil)
+	if managed && paths != nil {
+		t.Error("Got paths unexpectedly")
+	}
+}
+
+func TestGCPMetadataTokenSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			t.Error("Missing metadata flavor header")
+		}
+		w.Write([]byte("{\"access_token\": \"mock\", \"expires_in\": 3600, \"token_type\": \"Bearer\"}"))
+	}))
+	defer server.Close()
+
+	token, err := gcpMetadataTokenSource{URL: server.URL, Client: server.Client()}.Token()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if token.AccessToken != "mock" || !token.Valid() {
+		t.Error("Did not get the expected token back")
+	}
+}
+
+func TestFetchGCPSecrets(t *testing.T) {
+	gcpSecrets := make([]gcpSecret, 0)
+	for i := 0; i < 10; i++ {
+		gcpSecrets = append(gcpSecrets, gcpSecret{
+			Name:        "projects/mock/secrets/mock",
+			Labels:      map[string]string{Managed: "true"},
+			Annotations: map[string]string{Paths: "mock/mock"},
+		})
+	}
+
+	mu := &sync.Mutex{}
+	running, maxRunning := 0, 0
+	access := func(name string) (map[string]string, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return map[string]string{"mock": "mock"}, nil
+	}
+
+	secrets := fetchGCPSecrets(gcpSecrets, 2, access, "mock")
+	if len(secrets) != 10 {
+		t.Errorf("Expected 10 secrets, got %d", len(secrets))
+	}
+	if maxRunning > 2 {
+		t.Errorf("Expected at most 2 secrets to be accessed at once, got %d", maxRunning)
+	}
+}
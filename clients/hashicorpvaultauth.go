@@ -2,7 +2,9 @@ package clients
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"strings"
 
 	"github.com/hashicorp/vault/api"
 )
@@ -12,11 +14,31 @@ type HashicorpVaultAuth interface {
 	GetToken(client *api.Client) error
 }
 
+// vaultLoginPath provides the login path of an auth method, at its default mount unless another is given
+func vaultLoginPath(mountPath, defaultMount string) string {
+	mountPath = strings.Trim(strings.TrimPrefix(strings.Trim(mountPath, "/"), "auth/"), "/")
+	if mountPath == "" {
+		mountPath = defaultMount
+	}
+	return fmt.Sprintf("auth/%s/login", mountPath)
+}
+
+// setVaultToken sets the token from a login response on the client
+func setVaultToken(client *api.Client, vaultToken *api.Secret) error {
+	if vaultToken == nil || vaultToken.Auth == nil {
+		return errors.New("Vault login did not return a token")
+	}
+	client.SetToken(vaultToken.Auth.ClientToken)
+	return nil
+}
+
 // HashicorpVaultK8SAuth contains auth information for using kubernetes authentication method
 type HashicorpVaultK8SAuth struct {
 	IsPod      bool
 	Role       string
 	ConfigPath *string
+	// MountPath is where the auth method is mounted, defaulting to kubernetes
+	MountPath string
 }
 
 // GetToken retrieves a valid Hashicorp Vault token via kubernetes authentication method for integrating with the vault
@@ -42,12 +64,11 @@ func (auth HashicorpVaultK8SAuth) GetToken(client *api.Client) error {
 		return errors.New("Failed to load kubernetes client token")
 	}
 
-	vaultToken, err := client.Logical().Write("auth/kubernetes/login", map[string]interface{}{"jwt": token, "role": auth.Role})
+	vaultToken, err := client.Logical().Write(vaultLoginPath(auth.MountPath, "kubernetes"), map[string]interface{}{"jwt": token, "role": auth.Role})
 	if err != nil {
 		return err
 	}
-	client.SetToken(vaultToken.Auth.ClientToken)
-	return nil
+	return setVaultToken(client, vaultToken)
 }
 
 // HashicorpVaultTokenAuth contains auth information for using a pre-provided token to authenticate
@@ -68,6 +89,8 @@ func (auth HashicorpVaultTokenAuth) GetToken(client *api.Client) error {
 type HashicorpVaultApproleAuth struct {
 	RoleID   string
 	SecretID string
+	// MountPath is where the auth method is mounted, defaulting to approle
+	MountPath string
 }
 
 // GetToken retrieves a valid Hashicorp Vault token via approle authentication method for integrating with the vault
@@ -78,10 +101,107 @@ func (auth HashicorpVaultApproleAuth) GetToken(client *api.Client) error {
 	if auth.SecretID == "" {
 		return errors.New("No valid secret id set")
 	}
-	vaultToken, err := client.Logical().Write("auth/approle/login", map[string]interface{}{"role_id": auth.RoleID, "secret_id": auth.SecretID})
+	vaultToken, err := client.Logical().Write(vaultLoginPath(auth.MountPath, "approle"), map[string]interface{}{"role_id": auth.RoleID, "secret_id": auth.SecretID})
 	if err != nil {
 		return err
 	}
-	client.SetToken(vaultToken.Auth.ClientToken)
-	return nil
+	return setVaultToken(client, vaultToken)
+}
+
+// HashicorpVaultJWTAuth contains auth information for using the jwt auth method with a token read
+// from a file, such as a projected service account token
+type HashicorpVaultJWTAuth struct {
+	Role      string
+	TokenPath string
+	// MountPath is where the auth method is mounted, defaulting to jwt
+	MountPath string
+}
+
+// GetToken retrieves a valid Hashicorp Vault token via jwt authentication method for integrating with the vault.
+// The token file is read on every login, as projected tokens are rotated by kubernetes
+func (auth HashicorpVaultJWTAuth) GetToken(client *api.Client) error {
+	if auth.Role == "" {
+		return errors.New("No valid vault role provided")
+	}
+	if auth.TokenPath == "" {
+		return errors.New("No valid jwt path set")
+	}
+	tokenBytes, err := ioutil.ReadFile(auth.TokenPath)
+	if err != nil {
+		return err
+	}
+	token := strings.TrimSpace(string(tokenBytes))
+	if token == "" {
+		return fmt.Errorf("No jwt found in %s", auth.TokenPath)
+	}
+	vaultToken, err := client.Logical().Write(vaultLoginPath(auth.MountPath, "jwt"), map[string]interface{}{"jwt": token, "role": auth.Role})
+	if err != nil {
+		return err
+	}
+	return setVaultToken(client, vaultToken)
+}
+
+// HashicorpVaultCertAuth contains auth information for using the TLS certificate auth method
+type HashicorpVaultCertAuth struct {
+	// Name optionally selects the certificate role to log in against
+	Name          string
+	CertPath      string
+	KeyPath       string
+	SkipTLSVerify bool
+	// MountPath is where the auth method is mounted, defaulting to cert
+	MountPath string
+}
+
+// GetToken retrieves a valid Hashicorp Vault token via TLS certificate authentication method for integrating with the vault.
+// The certificate is only presented by a separate client used to log in, the token is then set on the main client
+func (auth HashicorpVaultCertAuth) GetToken(client *api.Client) error {
+	if auth.CertPath == "" || auth.KeyPath == "" {
+		return errors.New("No valid client certificate and key set")
+	}
+	config := api.DefaultConfig()
+	config.Address = client.Address()
+	if err := config.ConfigureTLS(&api.TLSConfig{ClientCert: auth.CertPath, ClientKey: auth.KeyPath, Insecure: auth.SkipTLSVerify}); err != nil {
+		return err
+	}
+	loginClient, err := api.NewClient(config)
+	if err != nil {
+		return err
+	}
+	loginClient.ClearToken()
+	if namespace := client.Headers().Get(vaultNamespaceHeader); namespace != "" {
+		loginClient.SetNamespace(namespace)
+	}
+
+	data := map[string]interface{}{}
+	if auth.Name != "" {
+		data["name"] = auth.Name
+	}
+	vaultToken, err := loginClient.Logical().Write(vaultLoginPath(auth.MountPath, "cert"), data)
+	if err != nil {
+		return err
+	}
+	return setVaultToken(client, vaultToken)
+}
+
+// HashicorpVaultUserpassAuth contains auth information for using the userpass auth method
+type HashicorpVaultUserpassAuth struct {
+	Username string
+	Password string
+	// MountPath is where the auth method is mounted, defaulting to userpass
+	MountPath string
+}
+
+// GetToken retrieves a valid Hashicorp Vault token via userpass authentication method for integrating with the vault
+func (auth HashicorpVaultUserpassAuth) GetToken(client *api.Client) error {
+	if auth.Username == "" {
+		return errors.New("No valid username set")
+	}
+	if auth.Password == "" {
+		return errors.New("No valid password set")
+	}
+	vaultToken, err := client.Logical().Write(fmt.Sprintf("%s/%s", vaultLoginPath(auth.MountPath, "userpass"), auth.Username), map[string]interface{}{"password": auth.Password})
+	if err != nil {
+		return err
+	}
+	return setVaultToken(client, vaultToken)
 }
@@ -0,0 +1,42 @@
+package clients
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestVaultLoginPath(t *testing.T) {
+	cases := map[string]string{
+		"":                    "auth/kubernetes/login",
+		"k8s-prod":            "auth/k8s-prod/login",
+		"auth/k8s-prod/":      "auth/k8s-prod/login",
+		"/clusters/k8s-prod/": "auth/clusters/k8s-prod/login",
+	}
+	for mountPath, expected := range cases {
+		if path := vaultLoginPath(mountPath, "kubernetes"); path != expected {
+			t.Errorf("Expected %s for mount %s, got %s", expected, mountPath, path)
+		}
+	}
+}
+
+func TestHashicorpVaultAuthValidation(t *testing.T) {
+	if err := (HashicorpVaultJWTAuth{Role: "mock", TokenPath: "/does/not/exist"}).GetToken(nil); err == nil {
+		t.Error("Expected an error for a missing token file")
+	}
+
+	file, err := ioutil.TempFile("", "mimir-jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("\n")
+	file.Close()
+
+	if err := (HashicorpVaultJWTAuth{Role: "mock", TokenPath: file.Name()}).GetToken(nil); err == nil {
+		t.Error("Expected an error for an empty token file")
+	}
+	if err := (HashicorpVaultUserpassAuth{Username: "mock"}).GetToken(nil); err == nil {
+		t.Error("Expected an error for a missing password")
+	}
+}
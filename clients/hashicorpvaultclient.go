@@ -2,10 +2,12 @@ package clients
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,15 +15,88 @@ import (
 	"github.com/hashicorp/vault/api"
 )
 
+// vaultNamespaceHeader is the header selecting the Hashicorp Vault Enterprise namespace of a request
+const vaultNamespaceHeader = "X-Vault-Namespace"
+
 // hashicorpVaultClient holds the required client and paths for integration with Hashicorp Vault
 type hashicorpVaultClient struct {
-	Client       *api.Client
-	dataPath     string
-	metadataPath string
+	Client        *api.Client
+	mount         string
+	path          string
+	dataPath      string
+	metadataPath  string
+	namespace     string
+	mapNamespaces bool
+	recursion     *vaultRecursion
+	flatten       bool
+	renewToken    bool
+	tokenManager  *vaultTokenManager
+	dynamic       *vaultDynamicSecrets
+	versions      *vaultVersionCache
+}
+
+// vaultVersionCache holds the kv engine version of the mount in each vault namespace, so mapped
+// namespaces only have their mounts listed once
+type vaultVersionCache struct {
+	mu       sync.Mutex
+	versions map[string]int
+}
+
+// vaultRecursion configures the discovery of secrets nested in directories below a namespace
+type vaultRecursion struct {
+	Separator string
+	MaxDepth  int
+}
+
+// HashicorpVaultOption is an optional setting applied to the Hashicorp Vault client
+type HashicorpVaultOption func(client *hashicorpVaultClient)
+
+// WithVaultRecursion makes the client look for secrets in directories nested below each namespace,
+// up to maxDepth directories deep. The nested path is joined by the separator to name the secret
+// in k8s, so secret/<namespace>/team/app is loaded as team-app when the separator is -
+func WithVaultRecursion(separator string, maxDepth int) HashicorpVaultOption {
+	return func(client *hashicorpVaultClient) {
+		if separator == "" {
+			separator = "-"
+		}
+		client.recursion = &vaultRecursion{Separator: separator, MaxDepth: maxDepth}
+	}
+}
+
+// WithVaultFlatten makes the client flatten nested objects in a secret into dotted keys, so
+// {"db": {"user": "mimir"}} is loaded as the key db.user, rather than as a JSON string under db
+func WithVaultFlatten() HashicorpVaultOption {
+	return func(client *hashicorpVaultClient) {
+		client.flatten = true
+	}
+}
+
+// WithVaultTokenRenewal keeps the token of the client valid in the background, for long running
+// processes. The token is renewed while it can be, and the client logs in again once it can not
+func WithVaultTokenRenewal() HashicorpVaultOption {
+	return func(client *hashicorpVaultClient) {
+		client.renewToken = true
+	}
+}
+
+// WithVaultNamespace makes the client log in and read secrets in a Hashicorp Vault Enterprise namespace
+func WithVaultNamespace(namespace string) HashicorpVaultOption {
+	return func(client *hashicorpVaultClient) {
+		client.namespace = strings.Trim(namespace, "/")
+	}
+}
+
+// WithVaultNamespaceMapping makes the client read the secrets of each k8s namespace from the vault
+// namespace of the same name, nested below any namespace set on the client, rather than from a
+// directory named after the k8s namespace in the mount. The mount is expected in each namespace
+func WithVaultNamespaceMapping() HashicorpVaultOption {
+	return func(client *hashicorpVaultClient) {
+		client.mapNamespaces = true
+	}
 }
 
 // NewHashicorpVaultClient provides a new SecretsManagerClient for using Hashicorp Vault
-func NewHashicorpVaultClient(path, url, mount string, skipTLSVerify bool, auth HashicorpVaultAuth) (SecretsManagerClient, error) {
+func NewHashicorpVaultClient(path, url, mount string, skipTLSVerify bool, auth HashicorpVaultAuth, options ...HashicorpVaultOption) (SecretsManagerClient, error) {
 	client, err := api.NewClient(&api.Config{
 		Address: url,
 		HttpClient: &http.Client{
@@ -33,20 +108,47 @@ func NewHashicorpVaultClient(path, url, mount string, skipTLSVerify bool, auth H
 	if err != nil {
 		return nil, err
 	}
+	hvClient := &hashicorpVaultClient{
+		Client:   client,
+		mount:    mount,
+		path:     path,
+		versions: &vaultVersionCache{versions: make(map[string]int)},
+	}
+	for _, option := range options {
+		option(hvClient)
+	}
+	if hvClient.namespace != "" {
+		client.SetNamespace(hvClient.namespace)
+	}
 	err = auth.GetToken(client)
 	if err != nil {
 		return nil, err
 	}
-	version, err := getVersion(mount, client)
-	if err != nil {
-		return nil, err
+	// When namespaces are mapped, the mount is looked up in the namespace of each request instead
+	if !hvClient.mapNamespaces {
+		version, err := getVersion(mount, client)
+		if err != nil {
+			return nil, err
+		}
+		hvClient.dataPath, hvClient.metadataPath = setupVaultPaths(version, mount, path)
+	}
+	if hvClient.dynamic != nil {
+		if err := hvClient.dynamic.resolveEngines(client); err != nil {
+			return nil, err
+		}
+	}
+	if hvClient.renewToken {
+		hvClient.tokenManager = newVaultTokenManager(client, auth)
+		go hvClient.tokenManager.run()
+	}
+	return hvClient, nil
+}
+
+// Close stops the management of the token of the client
+func (client hashicorpVaultClient) Close() {
+	if client.tokenManager != nil {
+		client.tokenManager.stop()
 	}
-	dataPath, metadataPath := setupVaultPaths(version, mount, path)
-	return &hashicorpVaultClient{
-		Client:       client,
-		dataPath:     dataPath,
-		metadataPath: metadataPath,
-	}, nil
 }
 
 // setValutPaths provides the data and metadata paths for vault integration
@@ -69,14 +171,92 @@ func setupVaultPaths(version int, mount, path string) (string, string) {
 	return dataPath, metadataPath
 }
 
-// GetSecrets will provide a slice of Secret type responses, for remote secrets located in Hashicorp Vault
+// vaultLocation is where the secrets of a k8s namespace are held in the vault, and the client to reach them
+type vaultLocation struct {
+	client   *api.Client
+	dataRoot string
+	listRoot string
+}
+
+// locate provides where the secrets of a k8s namespace are held in the vault. This is a directory
+// named after the namespace in the mount, or when namespaces are mapped, the mount in the vault
+// namespace named after it
+func (client hashicorpVaultClient) locate(namespace string) (*vaultLocation, error) {
+	if !client.mapNamespaces {
+		return &vaultLocation{
+			client:   client.Client,
+			dataRoot: fmt.Sprintf("%s/%s", client.dataPath, namespace),
+			listRoot: fmt.Sprintf("%s/%s", client.metadataPath, namespace),
+		}, nil
+	}
+
+	nsClient, err := client.Client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	nsClient.SetToken(client.Client.Token())
+	vaultNamespace := joinVaultNamespace(client.namespace, namespace)
+	nsClient.SetNamespace(vaultNamespace)
+	version, err := client.mountVersion(vaultNamespace, nsClient)
+	if err != nil {
+		return nil, err
+	}
+	dataPath, metadataPath := setupVaultPaths(version, client.mount, client.path)
+	return &vaultLocation{client: nsClient, dataRoot: dataPath, listRoot: metadataPath}, nil
+}
+
+// mountVersion provides the kv engine version of the mount in a vault namespace, listing the mounts
+// of the namespace only the first time it is asked for
+func (client hashicorpVaultClient) mountVersion(vaultNamespace string, nsClient *api.Client) (int, error) {
+	if client.versions == nil {
+		return getVersion(client.mount, nsClient)
+	}
+	client.versions.mu.Lock()
+	version, ok := client.versions.versions[vaultNamespace]
+	client.versions.mu.Unlock()
+	if ok {
+		return version, nil
+	}
+	version, err := getVersion(client.mount, nsClient)
+	if err != nil {
+		return 0, err
+	}
+	client.versions.mu.Lock()
+	client.versions.versions[vaultNamespace] = version
+	client.versions.mu.Unlock()
+	return version, nil
+}
+
+// joinVaultNamespace nests a vault namespace below a parent namespace, if there is one
+func joinVaultNamespace(parent, namespace string) string {
+	if parent == "" {
+		return namespace
+	}
+	return fmt.Sprintf("%s/%s", parent, namespace)
+}
+
+// GetSecrets will provide a slice of Secret type responses, for remote secrets located in Hashicorp
+// Vault. Namespaces whose secrets can not be located are returned in a FailedNamespacesError,
+// along with the secrets of the other namespaces
 func (client hashicorpVaultClient) GetSecrets(namespaces ...string) ([]*Secret, error) {
+	locations := make(map[string]*vaultLocation)
+	failed := make(map[string]error)
+	for _, namespace := range namespaces {
+		location, err := client.locate(namespace)
+		if err != nil {
+			log.Printf("Failed to locate the vault secrets for namespace %s: %s\n", namespace, err.Error())
+			failed[namespace] = err
+			continue
+		}
+		locations[namespace] = location
+	}
+
 	nc := make(chan *Secret)
 	wg1 := &sync.WaitGroup{}
 
-	for _, namespace := range namespaces {
+	for namespace, location := range locations {
 		wg1.Add(1)
-		go listVaultSecrets(nc, wg1, client.Client, client.metadataPath, namespace)
+		go listVaultSecrets(nc, wg1, location.client, location.listRoot, namespace, client.recursion)
 	}
 
 	go func() {
@@ -86,9 +266,18 @@ func (client hashicorpVaultClient) GetSecrets(namespaces ...string) ([]*Secret,
 
 	sc := make(chan *Secret)
 	wg2 := &sync.WaitGroup{}
+	seen := make(map[string]bool)
 	for secret := range nc {
+		// A nested secret can be mapped to the same name as another in the namespace
+		key := fmt.Sprintf("%s/%s", secret.Namespace, secret.Name)
+		if seen[key] {
+			log.Printf("Skipping vault secret %s/%s, the name %s is already used in namespace %s\n", secret.Namespace, secret.remotePath, secret.Name, secret.Namespace)
+			continue
+		}
+		seen[key] = true
 		wg2.Add(1)
-		go buildVaultSecret(sc, wg2, client.Client, client.dataPath, secret.Namespace, secret.Name)
+		location := locations[secret.Namespace]
+		go buildVaultSecret(sc, wg2, location.client, location.dataRoot, secret.Namespace, secret.Name, secret.remotePath, client.flatten)
 	}
 
 	go func() {
@@ -100,22 +289,47 @@ func (client hashicorpVaultClient) GetSecrets(namespaces ...string) ([]*Secret,
 	for secret := range sc {
 		secrets = append(secrets, secret)
 	}
+	if client.dynamic != nil {
+		for _, secret := range client.dynamic.load(client.Client, namespaces...) {
+			if seen[fmt.Sprintf("%s/%s", secret.Namespace, secret.Name)] {
+				log.Printf("Skipping dynamic secret %s/%s, the name is already used by a secret in the vault mount\n", secret.Namespace, secret.Name)
+				continue
+			}
+			secrets = append(secrets, secret)
+		}
+	}
+	if len(failed) > 0 {
+		return secrets, &FailedNamespacesError{Namespaces: failed}
+	}
 	return secrets, nil
 }
 
-// GetSecret will retrieve a remote secret from Hashicorp Vault
+// GetSecret will retrieve a remote secret from Hashicorp Vault. When namespaces are mapped, the
+// first directory of the path selects the vault namespace
 func (client hashicorpVaultClient) GetSecret(path string) (*Secret, error) {
-	vaultSecret, err := client.Client.Logical().Read(fmt.Sprintf("%s/%s", client.dataPath, path))
+	vc, readPath := client.Client, fmt.Sprintf("%s/%s", client.dataPath, path)
+	if client.mapNamespaces {
+		parts := strings.SplitN(path, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Secret path %s must start with the namespace it is held in", path)
+		}
+		location, err := client.locate(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		vc, readPath = location.client, fmt.Sprintf("%s/%s", location.dataRoot, parts[1])
+	}
+
+	vaultSecret, err := vc.Logical().Read(readPath)
 	if err != nil {
 		return nil, err
 	}
+	if vaultSecret == nil {
+		return nil, fmt.Errorf("Secret %s was not found in the vault", path)
+	}
 	secretData := make(map[string]string)
 	if data, ok := vaultSecret.Data["data"].(map[string]interface{}); ok {
-		for k, v := range data {
-			if vstr, ok := v.(string); ok {
-				secretData[k] = vstr
-			}
-		}
+		secretData = buildVaultSecretData(path, data, client.flatten)
 	}
 	splitPaths := strings.Split(path, "/")
 	lastPath := len(splitPaths) - 1
@@ -127,10 +341,21 @@ func (client hashicorpVaultClient) GetSecret(path string) (*Secret, error) {
 	return &Secret{Name: name, Namespace: namespace, Data: secretData}, nil
 }
 
-// listVaultSecrets will retrieve a list of secrets from Hashicorp Vault on the provided paths
-func listVaultSecrets(c chan<- *Secret, wg *sync.WaitGroup, client *api.Client, metadataPath, namespace string) {
+// listVaultSecrets will retrieve a list of secrets from Hashicorp Vault on the provided paths. The
+// list root is the metadata path holding the secrets of the namespace
+func listVaultSecrets(c chan<- *Secret, wg *sync.WaitGroup, client *api.Client, listRoot, namespace string, recursion *vaultRecursion) {
 	defer wg.Done()
-	secretsList, err := client.Logical().List(fmt.Sprintf("%s/%s", metadataPath, namespace))
+	walkVaultSecrets(c, client, listRoot, namespace, "", 0, recursion)
+}
+
+// walkVaultSecrets lists the secrets in a directory below a namespace, and when recursion is
+// enabled, the directories nested within it up to the max depth
+func walkVaultSecrets(c chan<- *Secret, client *api.Client, listRoot, namespace, subPath string, depth int, recursion *vaultRecursion) {
+	listPath := listRoot
+	if subPath != "" {
+		listPath = fmt.Sprintf("%s/%s", listPath, strings.TrimSuffix(subPath, "/"))
+	}
+	secretsList, err := client.Logical().List(listPath)
 	if err != nil {
 		log.Println(err.Error())
 		return
@@ -138,39 +363,137 @@ func listVaultSecrets(c chan<- *Secret, wg *sync.WaitGroup, client *api.Client,
 	if secretsList == nil {
 		return
 	}
-	loadVaultSecretsAtPath(c, namespace, secretsList.Data)
+	separator := ""
+	if recursion != nil {
+		separator = recursion.Separator
+	}
+	dirs := loadVaultSecretsAtPath(c, namespace, subPath, separator, secretsList.Data)
+	if recursion == nil {
+		return
+	}
+	for _, dir := range dirs {
+		if depth >= recursion.MaxDepth {
+			log.Printf("Not loading vault secrets under %s/%s%s, max depth of %d reached\n", namespace, subPath, dir, recursion.MaxDepth)
+			continue
+		}
+		walkVaultSecrets(c, client, listRoot, namespace, subPath+dir, depth+1, recursion)
+	}
 }
 
-// loadVaultSecretsAtPath loads secrets from a vault response, filtering out further downstream paths
-// if any are provided
-func loadVaultSecretsAtPath(c chan<- *Secret, namespace string, data map[string]interface{}) {
+// loadVaultSecretsAtPath loads secrets from a vault response for a directory below a namespace,
+// and provides the further downstream paths if any are found. Secrets found below the namespace
+// directory are named by their path, joined with the separator
+func loadVaultSecretsAtPath(c chan<- *Secret, namespace, subPath, separator string, data map[string]interface{}) []string {
+	dirs := make([]string, 0)
 	for k, v := range data {
 		if k == "keys" && v != nil {
 			for _, kv := range v.([]interface{}) {
-				if kvstr, ok := kv.(string); ok && kvstr[len(kvstr)-1:] != "/" {
-					c <- &Secret{Name: kvstr, Namespace: namespace}
+				kvstr, ok := kv.(string)
+				if !ok || kvstr == "" {
+					continue
+				}
+				if kvstr[len(kvstr)-1:] == "/" {
+					dirs = append(dirs, kvstr)
+					continue
 				}
+				secret := &Secret{Name: kvstr, Namespace: namespace}
+				if subPath != "" {
+					secret.remotePath = subPath + kvstr
+					secret.Name = strings.Replace(secret.remotePath, "/", separator, -1)
+				}
+				c <- secret
 			}
 		}
 	}
+	return dirs
 }
 
-// buildVaultSecret will build a Secret from secret data retrieved from the vault
-func buildVaultSecret(c chan<- *Secret, wg *sync.WaitGroup, client *api.Client, dataPath, namespace, name string) {
+// buildVaultSecret will build a Secret from secret data retrieved from the vault. The data root is
+// the data path holding the secrets of the namespace. The path is only needed when the secret is
+// nested, and so named differently to its location in the vault
+func buildVaultSecret(c chan<- *Secret, wg *sync.WaitGroup, client *api.Client, dataRoot, namespace, name, path string, flatten bool) {
 	defer wg.Done()
-	vaultSecret, err := client.Logical().Read(fmt.Sprintf("%s/%s/%s", dataPath, namespace, name))
+	if path == "" {
+		path = name
+	}
+	vaultSecret, err := client.Logical().Read(fmt.Sprintf("%s/%s", dataRoot, path))
 	if err != nil {
 		log.Println(err.Error())
+		return
+	}
+	if vaultSecret == nil {
+		return
 	}
 	secretData := make(map[string]string)
 	if data, ok := vaultSecret.Data["data"].(map[string]interface{}); ok {
-		for k, v := range data {
-			if vstr, ok := v.(string); ok {
-				secretData[k] = vstr
+		secretData = buildVaultSecretData(fmt.Sprintf("%s/%s", namespace, path), data, flatten)
+	}
+	c <- &Secret{Name: name, Namespace: namespace, Data: secretData}
+}
+
+// buildVaultSecretData converts the data of a vault secret into the string values held by k8s.
+// Numbers and booleans are formatted as they would be in JSON, and lists and objects are encoded
+// as JSON, unless flatten is set, where objects are instead expanded into dotted keys. A warning is
+// logged for every key that was not already a string
+func buildVaultSecretData(path string, data map[string]interface{}, flatten bool) map[string]string {
+	secretData := make(map[string]string)
+
+	keys := make([]string, 0)
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := data[k]
+		if vstr, ok := v.(string); ok {
+			secretData[k] = vstr
+			continue
+		}
+		if nested, ok := v.(map[string]interface{}); ok && flatten {
+			log.Printf("Flattening the object under key %s of vault secret %s into dotted keys\n", k, path)
+			for nk, nv := range buildVaultSecretData(path, nested, flatten) {
+				flatKey := fmt.Sprintf("%s.%s", k, nk)
+				if _, exists := data[flatKey]; exists {
+					log.Printf("Not flattening key %s of vault secret %s, the key already exists\n", flatKey, path)
+					continue
+				}
+				secretData[flatKey] = nv
 			}
+			continue
+		}
+		vstr, err := formatVaultValue(v)
+		if err != nil {
+			log.Printf("Dropping key %s of vault secret %s: %s\n", k, path, err.Error())
+			continue
 		}
+		log.Printf("Converted key %s of vault secret %s from %T to a string\n", k, path, v)
+		secretData[k] = vstr
 	}
-	c <- &Secret{Name: name, Namespace: namespace, Data: secretData}
+	return secretData
+}
+
+// formatVaultValue formats a non string value from a vault secret as a string
+func formatVaultValue(v interface{}) (string, error) {
+	switch value := v.(type) {
+	case nil:
+		return "", nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(value), nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
 }
 
 // getVersion will get the kv engine version used by the requested mount point
@@ -182,7 +505,7 @@ func getVersion(mount string, client *api.Client) (int, error) {
 	for mountKey, mountConfig := range mounts {
 		if mountKey == fmt.Sprintf("%s/", mount) {
 			if mountConfig.Type != "kv" {
-				return 0, errors.New("Only kv engine types are supported for sync")
+				return 0, errors.New("Only kv engine types are supported for the mount, dynamic engines are synced as dynamic secrets")
 			}
 			version, err := strconv.Atoi(mountConfig.Options["version"])
 			if err != nil {
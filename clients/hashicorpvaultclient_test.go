@@ -1,9 +1,15 @@
 package clients
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
 )
 
 func TestSetupVaultPaths(t *testing.T) {
@@ -44,7 +50,7 @@ func TestLoadVaultSecretsAtPath(t *testing.T) {
 		wg.Done()
 	}()
 
-	loadVaultSecretsAtPath(c, "mock", data)
+	dirs := loadVaultSecretsAtPath(c, "mock", "", "-", data)
 	close(c)
 
 	wg.Wait()
@@ -52,4 +58,176 @@ func TestLoadVaultSecretsAtPath(t *testing.T) {
 	if len(results) != 2 {
 		t.Error("Expected secret count did not match")
 	}
+	if len(dirs) != 1 || dirs[0] != "mockpath/" {
+		t.Error("Expected the nested path to be returned")
+	}
+}
+
+func TestLoadVaultSecretsAtNestedPath(t *testing.T) {
+	c := make(chan *Secret, 2)
+	data := map[string]interface{}{
+		"keys": []interface{}{"app", "deeper/"},
+	}
+
+	dirs := loadVaultSecretsAtPath(c, "mock", "team/", ".", data)
+	close(c)
+
+	secret := <-c
+	if secret.Name != "team.app" || secret.remotePath != "team/app" || secret.Namespace != "mock" {
+		t.Errorf("Unexpected nested secret %s at %s", secret.Name, secret.remotePath)
+	}
+	if len(dirs) != 1 || dirs[0] != "deeper/" {
+		t.Error("Expected the nested path to be returned")
+	}
+}
+
+func TestBuildVaultSecretData(t *testing.T) {
+	data := map[string]interface{}{
+		"string": "mock",
+		"number": json.Number("8200"),
+		"float":  1.5,
+		"bool":   true,
+		"null":   nil,
+		"list":   []interface{}{"a", json.Number("1")},
+		"object": map[string]interface{}{"b": "2", "a": map[string]interface{}{"c": false}},
+	}
+
+	expected := map[string]string{
+		"string": "mock",
+		"number": "8200",
+		"float":  "1.5",
+		"bool":   "true",
+		"null":   "",
+		"list":   `["a",1]`,
+		"object": `{"a":{"c":false},"b":"2"}`,
+	}
+	secretData := buildVaultSecretData("mock", data, false)
+	if len(secretData) != len(expected) {
+		t.Errorf("Expected %d keys, got %d", len(expected), len(secretData))
+	}
+	for k, v := range expected {
+		if secretData[k] != v {
+			t.Errorf("Expected %s for key %s, got %s", v, k, secretData[k])
+		}
+	}
+
+	secretData = buildVaultSecretData("mock", data, true)
+	if _, ok := secretData["object"]; ok {
+		t.Error("Object should have been flattened")
+	}
+	if secretData["object.b"] != "2" || secretData["object.a.c"] != "false" {
+		t.Error("Flattened keys were not as expected")
+	}
+	if secretData["list"] != `["a",1]` {
+		t.Error("Lists should still be encoded as JSON when flattening")
+	}
+}
+
+func TestVaultTokenManagerBackoff(t *testing.T) {
+	if backoff := nextVaultBackoff(vaultMinBackoff); backoff != 2*vaultMinBackoff {
+		t.Errorf("Expected the backoff to double, got %s", backoff)
+	}
+	if backoff := nextVaultBackoff(vaultMaxBackoff); backoff != vaultMaxBackoff {
+		t.Errorf("Expected the backoff to be capped, got %s", backoff)
+	}
+
+	auth := &countingVaultAuth{}
+	manager := newVaultTokenManager(nil, auth)
+	if !manager.login() || auth.calls != 1 {
+		t.Error("Expected a running manager to log in")
+	}
+	manager.stop()
+	manager.stop()
+	if manager.wait(time.Hour) || manager.login() {
+		t.Error("A stopped manager should not wait or log in")
+	}
+	if auth.calls != 1 {
+		t.Errorf("Expected a stopped manager not to call the auth method, got %d calls", auth.calls)
+	}
+}
+
+// countingVaultAuth is an auth method that always succeeds, counting how often it is called
+type countingVaultAuth struct {
+	calls int
+}
+
+func (auth *countingVaultAuth) GetToken(client *api.Client) error {
+	auth.calls++
+	return nil
+}
+
+func TestVaultNamespaces(t *testing.T) {
+	if ns := joinVaultNamespace("", "default"); ns != "default" {
+		t.Errorf("Expected default, got %s", ns)
+	}
+	if ns := joinVaultNamespace("team", "default"); ns != "team/default" {
+		t.Errorf("Expected team/default, got %s", ns)
+	}
+
+	client := &hashicorpVaultClient{dataPath: "secret/data", metadataPath: "secret/metadata"}
+	WithVaultNamespace("/team/")(client)
+	if client.namespace != "team" {
+		t.Errorf("Expected the namespace to be trimmed, got %s", client.namespace)
+	}
+	location, err := client.locate("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.dataRoot != "secret/data/default" || location.listRoot != "secret/metadata/default" {
+		t.Error("Unmapped namespaces should be directories in the mount")
+	}
+
+	WithVaultNamespaceMapping()(client)
+	if _, err := client.GetSecret("mock"); err == nil {
+		t.Error("Expected an error for a path without a namespace when namespaces are mapped")
+	}
+}
+
+func TestVaultMappedNamespaceFailures(t *testing.T) {
+	mountCalls := make(map[string]int)
+	mu := &sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.Header.Get("X-Vault-Namespace")
+		if r.URL.Path == "/v1/sys/mounts" {
+			mu.Lock()
+			mountCalls[namespace]++
+			mu.Unlock()
+			if namespace == "broken" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(`{"data":{"secret/":{"type":"kv","options":{"version":"2"}}}}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	vault, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &hashicorpVaultClient{
+		Client:        vault,
+		mount:         "secret",
+		mapNamespaces: true,
+		versions:      &vaultVersionCache{versions: make(map[string]int)},
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := client.GetSecrets("default", "broken")
+		failed, err := FailedNamespaces(err)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(failed) != 1 || failed[0] != "broken" {
+			t.Fatalf("Expected only the broken namespace to fail, got %v", failed)
+		}
+	}
+	if mountCalls["default"] != 1 {
+		t.Errorf("Expected the mounts of the default namespace to be listed once, got %d", mountCalls["default"])
+	}
+	if mountCalls["broken"] != 2 {
+		t.Errorf("Expected the mounts of a failed namespace to be listed again, got %d", mountCalls["broken"])
+	}
 }
@@ -0,0 +1,269 @@
+package clients
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// vaultDynamicEngines are the mount types that dynamic secrets can be synced from
+var vaultDynamicEngines = map[string]bool{
+	"database": true,
+	"aws":      true,
+	"pki":      true,
+}
+
+// VaultDynamicSource declares a k8s secret synced from a dynamic secrets engine, such as database
+// credentials or a PKI certificate, rather than from the kv mount
+type VaultDynamicSource struct {
+	Namespace string
+	Name      string
+	// Path is the engine path to load the secret from, eg. database/creds/app or pki/issue/web
+	Path string
+	// Params are written to the path, such as the common_name of a certificate. Without params,
+	// the path is read, apart from on pki mounts, which are always written to
+	Params map[string]interface{}
+}
+
+// ParseVaultDynamicSource parses a source given as namespace/name=path, with any params added to the
+// path as a query string, eg. default/web-tls=pki/issue/web?common_name=web.default.svc&ttl=72h
+func ParseVaultDynamicSource(value string) (*VaultDynamicSource, error) {
+	idx := strings.Index(value, "=")
+	if idx < 0 {
+		return nil, fmt.Errorf("Dynamic secret %s should be in the format namespace/name=path", value)
+	}
+	target, path := value[:idx], value[idx+1:]
+	targetParts := strings.Split(target, "/")
+	if len(targetParts) != 2 || targetParts[0] == "" || targetParts[1] == "" {
+		return nil, fmt.Errorf("Dynamic secret %s should be in the format namespace/name=path", value)
+	}
+
+	source := &VaultDynamicSource{
+		Namespace: targetParts[0],
+		Name:      targetParts[1],
+		Params:    make(map[string]interface{}),
+	}
+	if qIdx := strings.Index(path, "?"); qIdx >= 0 {
+		query, err := url.ParseQuery(path[qIdx+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid params for dynamic secret %s: %s", value, err.Error())
+		}
+		for k := range query {
+			source.Params[k] = query.Get(k)
+		}
+		path = path[:qIdx]
+	}
+	source.Path = strings.Trim(path, "/")
+	if source.Path == "" {
+		return nil, fmt.Errorf("Dynamic secret %s has no path", value)
+	}
+	return source, nil
+}
+
+// vaultLease tracks a dynamic secret that has been issued, so it can be renewed or issued again
+// before it expires
+type vaultLease struct {
+	id        string
+	renewable bool
+	lifetime  time.Duration
+	expires   time.Time
+	secret    *Secret
+}
+
+// due reports whether the secret should be renewed or issued again. This is once two thirds of its
+// lifetime has passed, leaving time for a failure to be retried. Secrets that do not expire are
+// never due
+func (lease *vaultLease) due(now time.Time) bool {
+	if lease.lifetime <= 0 {
+		return false
+	}
+	return !now.Before(lease.expires.Add(-lease.lifetime / 3))
+}
+
+// vaultDynamicSecrets holds the dynamic sources of a vault client, and the leases issued for them
+type vaultDynamicSecrets struct {
+	sources []*VaultDynamicSource
+	engines map[string]string
+	mu      sync.Mutex
+	leases  map[string]*vaultLease
+}
+
+// WithVaultDynamicSources makes the client sync secrets from dynamic secrets engines, alongside the
+// secrets in the kv mount. Leases are tracked, so that a long running process renews them, or
+// issues new secrets, before they expire
+func WithVaultDynamicSources(sources ...*VaultDynamicSource) HashicorpVaultOption {
+	return func(client *hashicorpVaultClient) {
+		if len(sources) == 0 {
+			return
+		}
+		client.dynamic = &vaultDynamicSecrets{
+			sources: sources,
+			engines: make(map[string]string),
+			leases:  make(map[string]*vaultLease),
+		}
+	}
+}
+
+// resolveEngines finds the type of the mount each source is on, checking it is a dynamic engine
+func (dynamic *vaultDynamicSecrets) resolveEngines(client *api.Client) error {
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		return err
+	}
+	for _, source := range dynamic.sources {
+		mount := findVaultMount(mounts, source.Path)
+		if mount == nil || !vaultDynamicEngines[mount.Type] {
+			return fmt.Errorf("Dynamic secret %s/%s is not on a database, aws or pki mount: %s", source.Namespace, source.Name, source.Path)
+		}
+		dynamic.engines[source.Path] = mount.Type
+	}
+	return nil
+}
+
+// findVaultMount provides the mount with the longest path that the path is within
+func findVaultMount(mounts map[string]*api.MountOutput, path string) *api.MountOutput {
+	var found *api.MountOutput
+	foundLen := 0
+	for mountPath, mount := range mounts {
+		if strings.HasPrefix(path+"/", mountPath) && len(mountPath) > foundLen {
+			found, foundLen = mount, len(mountPath)
+		}
+	}
+	return found
+}
+
+// load provides the dynamic secrets for the namespaces, reusing the secret of a lease until it is
+// due, then renewing the lease if it can be, or issuing a new secret if it can not
+func (dynamic *vaultDynamicSecrets) load(client *api.Client, namespaces ...string) []*Secret {
+	wanted := make(map[string]bool)
+	for _, namespace := range namespaces {
+		wanted[namespace] = true
+	}
+
+	dynamic.mu.Lock()
+	defer dynamic.mu.Unlock()
+
+	secrets := make([]*Secret, 0)
+	for _, source := range dynamic.sources {
+		if !wanted[source.Namespace] {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s", source.Namespace, source.Name)
+		lease, err := dynamic.refresh(client, source, dynamic.leases[key], time.Now())
+		if err != nil {
+			log.Printf("Failed to load dynamic secret %s from %s: %s\n", key, source.Path, err.Error())
+			// The last secret issued is still used while it has not expired
+			if lease = dynamic.leases[key]; lease == nil || (lease.lifetime > 0 && !time.Now().Before(lease.expires)) {
+				continue
+			}
+		}
+		dynamic.leases[key] = lease
+		secrets = append(secrets, copySecret(lease.secret))
+	}
+	return secrets
+}
+
+// refresh provides the lease for a source, renewing or replacing the existing lease when it is due.
+// A replaced lease is left to expire, as pods may still be using its credentials until they pick up
+// the new ones
+func (dynamic *vaultDynamicSecrets) refresh(client *api.Client, source *VaultDynamicSource, lease *vaultLease, now time.Time) (*vaultLease, error) {
+	if lease != nil && !lease.due(now) {
+		return lease, nil
+	}
+	if lease != nil && lease.renewable {
+		renewed, err := client.Sys().Renew(lease.id, int(lease.lifetime.Seconds()))
+		if err == nil && renewed != nil {
+			renewedLease := *lease
+			renewedLease.expires = now.Add(time.Duration(renewed.LeaseDuration) * time.Second)
+			// A lease that can not be extended past its due time has reached its max ttl
+			if !renewedLease.due(now) {
+				log.Printf("Renewed the lease of dynamic secret %s/%s until %s\n", source.Namespace, source.Name, renewedLease.expires.Format(time.RFC3339))
+				return &renewedLease, nil
+			}
+		} else if err != nil {
+			log.Printf("Failed to renew the lease of dynamic secret %s/%s, issuing a new secret: %s\n", source.Namespace, source.Name, err.Error())
+		}
+	}
+	return dynamic.issue(client, source, now)
+}
+
+// issue loads a new secret for a source from its engine
+func (dynamic *vaultDynamicSecrets) issue(client *api.Client, source *VaultDynamicSource, now time.Time) (*vaultLease, error) {
+	engine := dynamic.engines[source.Path]
+
+	var vaultSecret *api.Secret
+	var err error
+	if engine == "pki" || len(source.Params) > 0 {
+		vaultSecret, err = client.Logical().Write(source.Path, source.Params)
+	} else {
+		vaultSecret, err = client.Logical().Read(source.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if vaultSecret == nil || vaultSecret.Data == nil {
+		return nil, errors.New("No secret was returned")
+	}
+
+	lease := &vaultLease{
+		id:        vaultSecret.LeaseID,
+		renewable: vaultSecret.Renewable,
+		lifetime:  time.Duration(vaultSecret.LeaseDuration) * time.Second,
+		secret:    &Secret{Name: source.Name, Namespace: source.Namespace},
+	}
+	if engine == "pki" {
+		lease.secret.Data, err = buildPKISecretData(vaultSecret.Data)
+		if err != nil {
+			return nil, err
+		}
+		lease.secret.Type = string(core_v1.SecretTypeTLS)
+		// Certificates are not leased unless asked for, so the expiry of the certificate is used
+		if expiration, ok := vaultSecret.Data["expiration"].(json.Number); ok && lease.lifetime <= 0 {
+			if unix, err := expiration.Int64(); err == nil {
+				lease.lifetime = time.Unix(unix, 0).Sub(now)
+			}
+		}
+	} else {
+		lease.secret.Data = buildVaultSecretData(source.Path, vaultSecret.Data, false)
+	}
+	lease.expires = now.Add(lease.lifetime)
+
+	log.Printf("Issued dynamic secret %s/%s from %s, valid for %s\n", source.Namespace, source.Name, source.Path, lease.lifetime)
+	return lease, nil
+}
+
+// buildPKISecretData converts a certificate issued by a pki engine into the keys of a k8s TLS secret.
+// The certificate is followed by the chain of its issuers, and the issuing CA is kept as ca.crt
+func buildPKISecretData(data map[string]interface{}) (map[string]string, error) {
+	certificate, _ := data["certificate"].(string)
+	privateKey, _ := data["private_key"].(string)
+	if certificate == "" || privateKey == "" {
+		return nil, errors.New("The pki engine did not return a certificate and private key")
+	}
+
+	chain := []string{certificate}
+	if caChain, ok := data["ca_chain"].([]interface{}); ok {
+		for _, ca := range caChain {
+			if caStr, ok := ca.(string); ok && caStr != "" {
+				chain = append(chain, caStr)
+			}
+		}
+	}
+
+	secretData := map[string]string{
+		core_v1.TLSCertKey:       strings.Join(chain, "\n"),
+		core_v1.TLSPrivateKeyKey: privateKey,
+	}
+	if issuingCA, ok := data["issuing_ca"].(string); ok && issuingCA != "" {
+		secretData["ca.crt"] = issuingCA
+	}
+	return secretData, nil
+}
@@ -0,0 +1,134 @@
+package clients
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestParseVaultDynamicSource(t *testing.T) {
+	source, err := ParseVaultDynamicSource("default/web-tls=pki/issue/web?common_name=web.default.svc&ttl=72h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Namespace != "default" || source.Name != "web-tls" || source.Path != "pki/issue/web" {
+		t.Errorf("Unexpected source %+v", source)
+	}
+	if source.Params["common_name"] != "web.default.svc" || source.Params["ttl"] != "72h" {
+		t.Errorf("Unexpected params %v", source.Params)
+	}
+
+	source, err = ParseVaultDynamicSource("apps/db=database/creds/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Path != "database/creds/app" || len(source.Params) != 0 {
+		t.Errorf("Unexpected source %+v", source)
+	}
+
+	for _, value := range []string{"database/creds/app", "default=database/creds/app", "default/db=", "/db=database/creds/app"} {
+		if _, err := ParseVaultDynamicSource(value); err == nil {
+			t.Errorf("Expected an error for %s", value)
+		}
+	}
+}
+
+func TestFindVaultMount(t *testing.T) {
+	mounts := map[string]*api.MountOutput{
+		"secret/":        {Type: "kv"},
+		"database/":      {Type: "database"},
+		"database-prod/": {Type: "database"},
+		"pki/":           {Type: "pki"},
+		"pki/int/":       {Type: "pki"},
+	}
+	cases := map[string]string{
+		"database/creds/app":      "database/",
+		"database-prod/creds/app": "database-prod/",
+		"pki/int/issue/web":       "pki/int/",
+	}
+	for path, expected := range cases {
+		if mount := findVaultMount(mounts, path); mount != mounts[expected] {
+			t.Errorf("Expected mount %s for %s", expected, path)
+		}
+	}
+	if mount := findVaultMount(mounts, "aws/creds/app"); mount != nil {
+		t.Errorf("Expected no mount for aws/creds/app, got %+v", mount)
+	}
+}
+
+func TestBuildPKISecretData(t *testing.T) {
+	data, err := buildPKISecretData(map[string]interface{}{
+		"certificate": "cert",
+		"private_key": "key",
+		"issuing_ca":  "int",
+		"ca_chain":    []interface{}{"int", "root"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["tls.crt"] != "cert\nint\nroot" || data["tls.key"] != "key" || data["ca.crt"] != "int" {
+		t.Errorf("Unexpected TLS data %v", data)
+	}
+
+	if _, err := buildPKISecretData(map[string]interface{}{"certificate": "cert"}); err == nil {
+		t.Error("Expected an error for a missing private key")
+	}
+}
+
+func TestVaultLeaseDue(t *testing.T) {
+	now := time.Now()
+	lease := &vaultLease{lifetime: 90 * time.Minute, expires: now.Add(90 * time.Minute)}
+	if lease.due(now.Add(59 * time.Minute)) {
+		t.Error("Expected the lease not to be due before two thirds of its lifetime")
+	}
+	if !lease.due(now.Add(60 * time.Minute)) {
+		t.Error("Expected the lease to be due after two thirds of its lifetime")
+	}
+	if (&vaultLease{expires: now}).due(now.Add(time.Hour)) {
+		t.Error("Expected a secret that does not expire never to be due")
+	}
+
+	dynamic := &vaultDynamicSecrets{}
+	refreshed, err := dynamic.refresh(nil, &VaultDynamicSource{}, lease, now.Add(time.Minute))
+	if err != nil || refreshed != lease {
+		t.Error("Expected a lease that is not due to be reused")
+	}
+}
+
+func TestVaultLeaseNotRevokedWhenReplaced(t *testing.T) {
+	revoked := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/database/creds/app":
+			w.Write([]byte(`{"lease_id":"database/creds/app/new","lease_duration":3600,"renewable":false,"data":{"username":"new","password":"mock"}}`))
+		case strings.HasPrefix(r.URL.Path, "/v1/sys/leases/revoke"):
+			revoked = true
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	old := &vaultLease{id: "database/creds/app/old", lifetime: time.Hour, expires: now.Add(time.Minute)}
+	dynamic := &vaultDynamicSecrets{engines: map[string]string{"database/creds/app": "database"}}
+	lease, err := dynamic.refresh(client, &VaultDynamicSource{Namespace: "default", Name: "db", Path: "database/creds/app"}, old, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.id != "database/creds/app/new" || lease.secret.Data["username"] != "new" {
+		t.Errorf("Unexpected lease %+v", lease)
+	}
+	if revoked {
+		t.Error("Expected the replaced lease to be left to expire")
+	}
+}
@@ -0,0 +1,177 @@
+package clients
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// The wait between attempts to look up or log in again with a vault token that failed, doubled
+// after each failure up to the maximum
+const (
+	vaultMinBackoff = 5 * time.Second
+	vaultMaxBackoff = 5 * time.Minute
+)
+
+// vaultTokenManager keeps the token of a vault client valid for a long running process. A renewable
+// token is renewed as its ttl runs down, and once it can no longer be renewed, or renewal fails, the
+// client logs in again with its auth method
+type vaultTokenManager struct {
+	client   *api.Client
+	auth     HashicorpVaultAuth
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// newVaultTokenManager provides a token manager for a client that has already logged in
+func newVaultTokenManager(client *api.Client, auth HashicorpVaultAuth) *vaultTokenManager {
+	return &vaultTokenManager{
+		client: client,
+		auth:   auth,
+		stopCh: make(chan struct{}),
+	}
+}
+
+// stop ends the management of the token
+func (manager *vaultTokenManager) stop() {
+	manager.stopOnce.Do(func() { close(manager.stopCh) })
+}
+
+// run manages the token until stopped, or until the token is found to never expire
+func (manager *vaultTokenManager) run() {
+	backoff := vaultMinBackoff
+	for {
+		secret, err := manager.client.Auth().Token().LookupSelf()
+		if err != nil {
+			log.Printf("Failed to look up the vault token, logging in again in %s: %s\n", backoff, err.Error())
+			if !manager.wait(backoff) {
+				return
+			}
+			backoff = nextVaultBackoff(backoff)
+			if !manager.login() {
+				return
+			}
+			continue
+		}
+		backoff = vaultMinBackoff
+
+		renewable, _ := secret.TokenIsRenewable()
+		ttl, _ := secret.TokenTTL()
+		if ttl <= 0 {
+			log.Println("The vault token does not expire, so will not be renewed")
+			return
+		}
+		vaultTokenExpiry.Set(float64(time.Now().Add(ttl).Unix()))
+
+		if renewable {
+			log.Printf("Renewing the vault token, which expires in %s\n", ttl)
+			err = manager.renew(ttl)
+			if err != nil {
+				log.Printf("Failed to renew the vault token, logging in again: %s\n", err.Error())
+			} else {
+				log.Println("The vault token has reached its maximum ttl, logging in again")
+			}
+		} else {
+			// Logging in again with two thirds of the ttl left gives time to retry a failure
+			log.Printf("The vault token is not renewable, logging in again before it expires in %s\n", ttl)
+			if !manager.wait(ttl * 2 / 3) {
+				return
+			}
+		}
+
+		if !manager.login() {
+			return
+		}
+	}
+}
+
+// renew keeps renewing the token until it can not be renewed any further, or renewal fails
+func (manager *vaultTokenManager) renew(ttl time.Duration) error {
+	renewer, err := manager.client.NewRenewer(&api.RenewerInput{
+		Secret: &api.Secret{
+			Auth: &api.SecretAuth{
+				ClientToken:   manager.client.Token(),
+				Renewable:     true,
+				LeaseDuration: int(ttl.Seconds()),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	go renewer.Renew()
+	defer renewer.Stop()
+
+	for {
+		select {
+		case err := <-renewer.DoneCh():
+			if err != nil {
+				vaultTokenRenewals.WithLabelValues(resultLabel(err)).Inc()
+			}
+			return err
+		case renewal := <-renewer.RenewCh():
+			vaultTokenRenewals.WithLabelValues(resultLabel(nil)).Inc()
+			if renewal.Secret != nil && renewal.Secret.Auth != nil {
+				duration := time.Duration(renewal.Secret.Auth.LeaseDuration) * time.Second
+				vaultTokenExpiry.Set(float64(renewal.RenewedAt.Add(duration).Unix()))
+				log.Printf("Renewed the vault token, which now expires in %s\n", duration)
+			}
+		case <-manager.stopCh:
+			return nil
+		}
+	}
+}
+
+// login logs in to vault again with the auth method of the client, retrying with a backoff until
+// it succeeds. It reports false if the manager was stopped first, checking before each attempt so a
+// client being torn down is never given a new token
+func (manager *vaultTokenManager) login() bool {
+	backoff := vaultMinBackoff
+	for {
+		if manager.stopped() {
+			return false
+		}
+		err := manager.auth.GetToken(manager.client)
+		vaultTokenLogins.WithLabelValues(resultLabel(err)).Inc()
+		if err == nil {
+			log.Println("Logged in to vault again")
+			return true
+		}
+		log.Printf("Failed to log in to vault, retrying in %s: %s\n", backoff, err.Error())
+		if !manager.wait(backoff) {
+			return false
+		}
+		backoff = nextVaultBackoff(backoff)
+	}
+}
+
+// stopped reports whether the manager has been stopped
+func (manager *vaultTokenManager) stopped() bool {
+	select {
+	case <-manager.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait sleeps for the duration, reporting false if the manager was stopped first
+func (manager *vaultTokenManager) wait(d time.Duration) bool {
+	select {
+	case <-manager.stopCh:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// nextVaultBackoff doubles a backoff, up to the maximum
+func nextVaultBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > vaultMaxBackoff {
+		return vaultMaxBackoff
+	}
+	return backoff
+}
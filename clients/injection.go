@@ -0,0 +1,168 @@
+package clients
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// invalidEnvChars matches the characters that can not be used in a portable env var name
+var invalidEnvChars = regexp.MustCompile("[^A-Za-z0-9_]")
+
+// EnvInjection configures how the keys of a secret are injected into containers as env vars
+type EnvInjection struct {
+	// Keys maps the keys of the secret to inject to their env var name. An empty name is derived
+	// from the key. When no keys are set, every key of the secret is injected
+	Keys map[string]string
+	// Prefix is added to the names derived from keys
+	Prefix string
+	// Sanitize replaces characters that are not valid in a portable env var name with _
+	Sanitize bool
+}
+
+// ParseEnvInjection reads the env injection settings from the annotations of a pod. The keys
+// annotation is a comma separated list of keys, each optionally mapped to a name as key=NAME
+func ParseEnvInjection(annotations map[string]string) (*EnvInjection, error) {
+	injection := &EnvInjection{
+		Keys:   make(map[string]string),
+		Prefix: annotations[EnvPrefix],
+	}
+
+	if sanitize, ok := annotations[EnvSanitize]; ok {
+		var err error
+		injection.Sanitize, err = strconv.ParseBool(sanitize)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value %s for annotation %s", sanitize, EnvSanitize)
+		}
+	}
+
+	for _, entry := range strings.Split(annotations[EnvKeys], ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, name := entry, ""
+		if idx := strings.Index(entry, "="); idx >= 0 {
+			key, name = strings.TrimSpace(entry[:idx]), strings.TrimSpace(entry[idx+1:])
+		}
+		if key == "" {
+			return nil, fmt.Errorf("Invalid entry %s for annotation %s", entry, EnvKeys)
+		}
+		injection.Keys[key] = name
+	}
+	return injection, nil
+}
+
+// BuildEnvVars provides the env vars, sorted by name, that reference the keys of the secret
+func (injection *EnvInjection) BuildEnvVars(secretName string, data map[string][]byte) ([]core_v1.EnvVar, error) {
+	keys := injection.Keys
+	if len(keys) == 0 {
+		keys = make(map[string]string)
+		for k := range data {
+			keys[k] = ""
+		}
+	}
+
+	envs := make([]core_v1.EnvVar, 0)
+	names := make(map[string]string)
+	for key, name := range keys {
+		if _, ok := data[key]; !ok {
+			return nil, fmt.Errorf("Key %s was not found in secret %s", key, secretName)
+		}
+		if name == "" {
+			name = injection.Prefix + key
+			if injection.Sanitize {
+				name = sanitizeEnvName(name)
+			}
+		}
+		if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+			return nil, fmt.Errorf("Key %s can not be used as the env var %s: %s", key, name, strings.Join(errs, ", "))
+		}
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("Keys %s and %s would both be injected as the env var %s", other, key, name)
+		}
+		names[name] = key
+
+		selector := &core_v1.SecretKeySelector{Key: key}
+		selector.Name = secretName
+		envs = append(envs, core_v1.EnvVar{
+			Name: name,
+			ValueFrom: &core_v1.EnvVarSource{
+				SecretKeyRef: selector,
+			},
+		})
+	}
+
+	sort.Slice(envs, func(i, j int) bool { return envs[i].Name < envs[j].Name })
+	return envs, nil
+}
+
+// sanitizeEnvName replaces the characters of a name that are not valid in a portable env var
+// name with _, and makes sure it does not start with a digit
+func sanitizeEnvName(name string) string {
+	name = invalidEnvChars.ReplaceAllString(name, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// ContainerFilter selects the containers of a pod that a secret is injected into
+type ContainerFilter struct {
+	// Include limits injection to the named containers. When empty, all containers are included
+	Include map[string]bool
+	// Exclude stops injection into the named containers, even if included
+	Exclude map[string]bool
+}
+
+// ParseContainerFilter reads the containers to include and exclude from the annotations of a pod
+func ParseContainerFilter(annotations map[string]string) *ContainerFilter {
+	return &ContainerFilter{
+		Include: parseNameList(annotations[Containers]),
+		Exclude: parseNameList(annotations[ExcludeContainers]),
+	}
+}
+
+// Matches reports whether a secret should be injected into the named container
+func (filter *ContainerFilter) Matches(name string) bool {
+	if filter.Exclude[name] {
+		return false
+	}
+	return len(filter.Include) == 0 || filter.Include[name]
+}
+
+// Validate checks that every included container exists in the pod, so a mistyped name is not
+// silently ignored
+func (filter *ContainerFilter) Validate(spec core_v1.PodSpec) error {
+	names := make(map[string]bool)
+	for _, container := range append(spec.InitContainers, spec.Containers...) {
+		names[container.Name] = true
+	}
+	missing := make([]string, 0)
+	for name := range filter.Include {
+		if !names[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("Containers %s named in annotation %s were not found in the pod", strings.Join(missing, ", "), Containers)
+	}
+	return nil
+}
+
+// parseNameList reads a comma separated list of names into a set
+func parseNameList(value string) map[string]bool {
+	names := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
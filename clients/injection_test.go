@@ -0,0 +1,111 @@
+package clients
+
+import (
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+func TestParseEnvInjection(t *testing.T) {
+	injection, err := ParseEnvInjection(map[string]string{
+		EnvKeys:     "db-user=DB_USER, db-pass,",
+		EnvPrefix:   "APP_",
+		EnvSanitize: "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(injection.Keys) != 2 || injection.Keys["db-user"] != "DB_USER" || injection.Keys["db-pass"] != "" {
+		t.Error("Keys were not parsed as expected")
+	}
+	if injection.Prefix != "APP_" || !injection.Sanitize {
+		t.Error("Prefix or sanitize were not parsed as expected")
+	}
+
+	if _, err := ParseEnvInjection(map[string]string{EnvSanitize: "mock"}); err == nil {
+		t.Error("Expected an error for an invalid sanitize switch")
+	}
+	if _, err := ParseEnvInjection(map[string]string{EnvKeys: "=MOCK"}); err == nil {
+		t.Error("Expected an error for a mapping without a key")
+	}
+}
+
+func TestBuildEnvVars(t *testing.T) {
+	data := map[string][]byte{
+		"db-user": []byte("mock"),
+		"db-pass": []byte("mock"),
+		"1key":    []byte("mock"),
+	}
+
+	injection := &EnvInjection{Prefix: "", Sanitize: true}
+	envs, err := injection.BuildEnvVars("mock", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"_1key", "db_pass", "db_user"}
+	if len(envs) != len(expected) {
+		t.Fatalf("Expected %d env vars, got %d", len(expected), len(envs))
+	}
+	for idx, name := range expected {
+		if envs[idx].Name != name {
+			t.Errorf("Expected env var %s, got %s", name, envs[idx].Name)
+		}
+	}
+	if envs[2].ValueFrom.SecretKeyRef.Key != "db-user" || envs[2].ValueFrom.SecretKeyRef.Name != "mock" {
+		t.Error("Env var does not reference the secret key")
+	}
+
+	injection = &EnvInjection{Keys: map[string]string{"db-user": "USER", "db-pass": ""}, Prefix: "APP_", Sanitize: true}
+	envs, err = injection.BuildEnvVars("mock", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envs) != 2 || envs[0].Name != "APP_db_pass" || envs[1].Name != "USER" {
+		t.Error("Only the selected keys should be injected, with mapped names kept as is")
+	}
+
+	injection = &EnvInjection{Keys: map[string]string{"missing": ""}}
+	if _, err := injection.BuildEnvVars("mock", data); err == nil {
+		t.Error("Expected an error for a selected key missing from the secret")
+	}
+
+	injection = &EnvInjection{}
+	if _, err := injection.BuildEnvVars("mock", map[string][]byte{"a key": []byte("mock")}); err == nil {
+		t.Error("Expected an error for an invalid env var name without sanitize")
+	}
+
+	injection = &EnvInjection{Sanitize: true}
+	if _, err := injection.BuildEnvVars("mock", map[string][]byte{"a-b": []byte("mock"), "a.b": []byte("mock")}); err == nil {
+		t.Error("Expected an error for keys sanitized to the same name")
+	}
+}
+
+func TestContainerFilter(t *testing.T) {
+	spec := core_v1.PodSpec{
+		InitContainers: []core_v1.Container{core_v1.Container{Name: "init"}},
+		Containers:     []core_v1.Container{core_v1.Container{Name: "app"}, core_v1.Container{Name: "istio-proxy"}},
+	}
+
+	filter := ParseContainerFilter(map[string]string{})
+	if !filter.Matches("app") || !filter.Matches("istio-proxy") {
+		t.Error("All containers should match without annotations")
+	}
+
+	filter = ParseContainerFilter(map[string]string{ExcludeContainers: "istio-proxy"})
+	if !filter.Matches("app") || filter.Matches("istio-proxy") {
+		t.Error("Excluded container should not match")
+	}
+
+	filter = ParseContainerFilter(map[string]string{Containers: "app, init", ExcludeContainers: "init"})
+	if !filter.Matches("app") || filter.Matches("init") || filter.Matches("istio-proxy") {
+		t.Error("Only included containers that are not excluded should match")
+	}
+	if err := filter.Validate(spec); err != nil {
+		t.Error(err)
+	}
+
+	filter = ParseContainerFilter(map[string]string{Containers: "missing"})
+	if err := filter.Validate(spec); err == nil {
+		t.Error("Expected an error for an included container missing from the pod")
+	}
+}
@@ -1,12 +1,18 @@
 package clients
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
 
 	core_v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -37,6 +43,20 @@ func NewK8SClient(isPod bool, configPath *string) (*kubernetes.Clientset, error)
 	return kubernetes.NewForConfig(config)
 }
 
+// NewK8SDynamicClient loads a new dynamic k8s client, for resources that are not served at a fixed api version
+func NewK8SDynamicClient(isPod bool, configPath *string) (dynamic.Interface, error) {
+	config, err := getRestConfig(isPod, configPath)
+
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("Failed to load kubernetes config")
+	}
+
+	return dynamic.NewForConfig(config)
+}
+
 // GetNamespaces retrieves a list of namespaces from the cluster as a slice of strings
 func GetNamespaces(client *kubernetes.Clientset) ([]string, error) {
 	k8sNamespaces, err := client.CoreV1().Namespaces().List(meta_v1.ListOptions{})
@@ -50,14 +70,87 @@ func GetNamespaces(client *kubernetes.Clientset) ([]string, error) {
 	return namespaces, nil
 }
 
-// ManageSecrets is where a slice of Secret created from a backend secrets manager is parsed and
-// then created or updated in kubernetes. Secrets already in the cluster and marked as managed by
-// by mimir and share the same backend source, will be deleted if a corresponding secret from the
-// backend can not be found in the slice.
-func ManageSecrets(client *kubernetes.Clientset, mgr SecretsManager, secrets ...*Secret) error {
+// SyncSummary counts the changes made to secrets in kubernetes during a single sync
+type SyncSummary struct {
+	Created int
+	Updated int
+	Deleted int
+	Skipped int
+}
+
+// Written is the total number of secrets that were written to kubernetes
+func (summary SyncSummary) Written() int {
+	return summary.Created + summary.Updated + summary.Deleted
+}
+
+// String provides a short human readable form of the summary for logging
+func (summary SyncSummary) String() string {
+	return fmt.Sprintf("%d written (%d created, %d updated, %d deleted), %d skipped as unchanged",
+		summary.Written(), summary.Created, summary.Updated, summary.Deleted, summary.Skipped)
+}
+
+// SecretAction is the kind of change mimir makes to a secret in kubernetes
+type SecretAction string
+
+const (
+	// CreateSecret denotes a secret from the backend that does not yet exist in kubernetes
+	CreateSecret SecretAction = "create"
+	// UpdateSecret denotes a managed secret that will be overwritten from the backend
+	UpdateSecret SecretAction = "update"
+	// DeleteSecret denotes a managed secret that could no longer be found in the backend
+	DeleteSecret SecretAction = "delete"
+	// UnchangedSecret denotes a managed secret that already matches the backend
+	UnchangedSecret SecretAction = "unchanged"
+)
+
+// SecretChange is a single planned change to a secret in kubernetes. Only the names of the
+// keys are held, so a change is always safe to print
+type SecretChange struct {
+	Action      SecretAction `json:"action"`
+	Namespace   string       `json:"namespace"`
+	Name        string       `json:"name"`
+	AddedKeys   []string     `json:"addedKeys,omitempty"`
+	ChangedKeys []string     `json:"changedKeys,omitempty"`
+	RemovedKeys []string     `json:"removedKeys,omitempty"`
+	secret      *core_v1.Secret
+	// recreate is set when the type of the secret changes, as it can not be updated in place
+	recreate bool
+}
+
+// SecretsPlan is the full set of changes that a sync will make to secrets in kubernetes
+type SecretsPlan struct {
+	Backend SecretsManager  `json:"backend"`
+	Changes []*SecretChange `json:"changes"`
+}
+
+// SkipDeletes removes the deletes planned in the namespaces, for namespaces whose secrets could not
+// be loaded from the backend
+func (plan *SecretsPlan) SkipDeletes(namespaces ...string) {
+	skip := make(map[string]bool)
+	for _, namespace := range namespaces {
+		skip[namespace] = true
+	}
+	changes := make([]*SecretChange, 0)
+	for _, change := range plan.Changes {
+		if change.Action == DeleteSecret && skip[change.Namespace] {
+			log.Printf("Not deleting secret %s in namespace %s, the secrets of the namespace failed to load\n", change.Name, change.Namespace)
+			continue
+		}
+		changes = append(changes, change)
+	}
+	plan.Changes = changes
+}
+
+// PlanSecrets works out what needs to change in kubernetes for a slice of Secret created from a
+// backend secrets manager, without making any changes to the cluster. Secrets already in the
+// cluster that are marked as managed by mimir and share the same backend source are planned for
+// deletion if a corresponding secret from the backend can not be found in the slice. The plan is
+// then made with ApplyPlan
+func PlanSecrets(client *kubernetes.Clientset, mgr SecretsManager, secrets ...*Secret) (*SecretsPlan, error) {
+	plan := &SecretsPlan{Backend: mgr, Changes: make([]*SecretChange, 0)}
 	namespaces, err := GetNamespaces(client)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	for _, namespace := range namespaces {
 		nsSecrets := make([]*Secret, 0)
@@ -71,53 +164,184 @@ func ManageSecrets(client *kubernetes.Clientset, mgr SecretsManager, secrets ...
 
 		k8sSecrets, err := client.CoreV1().Secrets(namespace).List(meta_v1.ListOptions{})
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		managedSecrets := getManagedSecrets(k8sSecrets.Items, mgr)
+		for _, legacy := range legacySources[mgr] {
+			managedSecrets = append(managedSecrets, getManagedSecrets(k8sSecrets.Items, legacy)...)
+		}
+		plan.Changes = append(plan.Changes, planNamespaceSecrets(namespace, mgr, nsSecrets, managedSecrets)...)
+	}
+	return plan, nil
+}
 
-		for _, nsSecret := range nsSecrets {
-			hasSecret := func() bool {
-				for _, k8sSecret := range managedSecrets {
-					if nsSecret.Name == k8sSecret.Name {
-						return true
-					}
-				}
-				return false
-			}()
-			if hasSecret {
-				if _, err := client.CoreV1().Secrets(namespace).Update(BuildK8SSecret(nsSecret, mgr)); err != nil {
-					return err
+// planNamespaceSecrets compares the secrets from the backend for a single namespace against
+// those managed by mimir in the namespace, and provides the changes needed to bring them in line
+func planNamespaceSecrets(namespace string, mgr SecretsManager, nsSecrets []*Secret, managedSecrets []core_v1.Secret) []*SecretChange {
+	changes := make([]*SecretChange, 0)
+
+	sort.Slice(nsSecrets, func(i, j int) bool { return nsSecrets[i].Name < nsSecrets[j].Name })
+	for _, nsSecret := range nsSecrets {
+		k8sSecret, err := BuildK8SSecret(nsSecret, mgr)
+		if err != nil {
+			log.Printf("Skipping secret %s in namespace %s: %s\n", nsSecret.Name, namespace, err.Error())
+			continue
+		}
+		change := &SecretChange{
+			Action:    CreateSecret,
+			Namespace: namespace,
+			Name:      nsSecret.Name,
+			secret:    k8sSecret,
+		}
+		existing := func() *core_v1.Secret {
+			for idx := range managedSecrets {
+				if managedSecrets[idx].Name == nsSecret.Name {
+					return &managedSecrets[idx]
 				}
-				log.Printf("Updated secret: %s in namespace %s\n", nsSecret.Name, nsSecret.Namespace)
+			}
+			return nil
+		}()
+		if existing != nil {
+			hash := k8sSecret.Annotations[Hash]
+			if existing.Annotations[Hash] == hash && hashK8SSecret(existing) == hash {
+				change.Action = UnchangedSecret
 			} else {
-				if _, err := client.CoreV1().Secrets(namespace).Create(BuildK8SSecret(nsSecret, mgr)); err != nil {
-					return err
-				}
-				log.Printf("Created secret: %s in namespace %s\n", nsSecret.Name, nsSecret.Namespace)
+				change.Action = UpdateSecret
+				change.recreate = existing.Type != k8sSecret.Type
+				change.AddedKeys, change.ChangedKeys, change.RemovedKeys = diffSecretKeys(existing.Data, k8sSecret.Data)
 			}
+		} else {
+			change.AddedKeys, _, _ = diffSecretKeys(nil, k8sSecret.Data)
 		}
+		changes = append(changes, change)
+	}
 
-		for _, k8sSecret := range managedSecrets {
-			nsSecret := func() *Secret {
-				for _, nsSecret := range nsSecrets {
-					if nsSecret.Name == k8sSecret.Name {
-						return nsSecret
-					}
-				}
-				return nil
-			}()
-			if nsSecret == nil {
-				if err := client.CoreV1().Secrets(namespace).Delete(k8sSecret.Name, &meta_v1.DeleteOptions{}); err != nil {
-					return nil
+	for _, k8sSecret := range managedSecrets {
+		nsSecret := func() *Secret {
+			for _, nsSecret := range nsSecrets {
+				if nsSecret.Name == k8sSecret.Name {
+					return nsSecret
 				}
-				log.Printf("Deleted secret: %s in namespace %s\n", k8sSecret.Name, namespace)
 			}
+			return nil
+		}()
+		// Secrets of a legacy source are only adopted by the secret that replaces them, never deleted
+		source, hasSource := k8sSecret.Annotations[Source]
+		if nsSecret == nil && (!hasSource || source == string(mgr)) {
+			_, _, removed := diffSecretKeys(k8sSecret.Data, nil)
+			changes = append(changes, &SecretChange{
+				Action:      DeleteSecret,
+				Namespace:   namespace,
+				Name:        k8sSecret.Name,
+				RemovedKeys: removed,
+			})
 		}
 	}
+	return changes
+}
+
+// diffSecretKeys provides sorted lists of the names of the keys that were added, changed or
+// removed between two sets of secret data
+func diffSecretKeys(current, desired map[string][]byte) (added, changed, removed []string) {
+	for k, v := range desired {
+		cv, ok := current[k]
+		if !ok {
+			added = append(added, k)
+		} else if !bytes.Equal(cv, v) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range current {
+		if _, ok := desired[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return added, changed, removed
+}
+
+// ApplyPlan makes the changes held in a plan to the secrets in kubernetes
+func ApplyPlan(client *kubernetes.Clientset, plan *SecretsPlan) (*SyncSummary, error) {
+	summary := &SyncSummary{}
+	for _, change := range plan.Changes {
+		if err := applyChange(client, change, summary); err != nil {
+			return summary, err
+		}
+		secretChanges.WithLabelValues(string(plan.Backend), string(change.Action)).Inc()
+	}
+	return summary, nil
+}
+
+// applyChange makes a single planned change to a secret in kubernetes, counting it in the summary
+func applyChange(client *kubernetes.Clientset, change *SecretChange, summary *SyncSummary) error {
+	switch change.Action {
+	case CreateSecret:
+		if _, err := client.CoreV1().Secrets(change.Namespace).Create(change.secret); err != nil {
+			return err
+		}
+		summary.Created++
+		log.Printf("Created secret: %s in namespace %s\n", change.Name, change.Namespace)
+	case UpdateSecret:
+		if change.recreate {
+			if err := recreateSecret(client, change.secret); err != nil {
+				return err
+			}
+		} else if _, err := client.CoreV1().Secrets(change.Namespace).Update(change.secret); err != nil {
+			return err
+		}
+		summary.Updated++
+		log.Printf("Updated secret: %s in namespace %s\n", change.Name, change.Namespace)
+	case DeleteSecret:
+		if err := client.CoreV1().Secrets(change.Namespace).Delete(change.Name, &meta_v1.DeleteOptions{}); err != nil {
+			return err
+		}
+		summary.Deleted++
+		log.Printf("Deleted secret: %s in namespace %s\n", change.Name, change.Namespace)
+	case UnchangedSecret:
+		summary.Skipped++
+	}
+	return nil
+}
+
+// recreateSecret deletes and creates a secret again, for changes that can not be made in place. The
+// data is validated against the new type before anything is deleted, and the original secret is
+// restored if the new one can not be created
+func recreateSecret(client kubernetes.Interface, secret *core_v1.Secret) error {
+	if err := validateSecretData(secret.Type, secret.Data); err != nil {
+		return err
+	}
+	secrets := client.CoreV1().Secrets(secret.Namespace)
+	original, err := secrets.Get(secret.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if err := secrets.Delete(secret.Name, &meta_v1.DeleteOptions{}); err != nil {
+		return err
+	}
+	secret = secret.DeepCopy()
+	secret.ResourceVersion = ""
+	if _, err := secrets.Create(secret); err != nil {
+		restored := original.DeepCopy()
+		restored.ResourceVersion = ""
+		restored.UID = ""
+		if _, restoreErr := secrets.Create(restored); restoreErr != nil {
+			return fmt.Errorf("Failed to recreate secret %s in namespace %s: %s, and failed to restore the original: %s", secret.Name, secret.Namespace, err.Error(), restoreErr.Error())
+		}
+		return err
+	}
 	return nil
 }
 
+// legacySources are the sources that earlier versions of mimir marked the secrets of a backend with.
+// Secrets synced from Azure Key Vault were marked as aws, so they are adopted by the azure backend
+// when a secret of the same name is synced, which rewrites their source
+var legacySources = map[SecretsManager][]SecretsManager{
+	Azure: {AWS},
+}
+
 // getManagedSecrets gets a slice of k8s secrets that are managed by mimir currently in
 // the cluster
 func getManagedSecrets(secrets []core_v1.Secret, mgr SecretsManager) []core_v1.Secret {
@@ -140,14 +364,15 @@ func getManagedSecrets(secrets []core_v1.Secret, mgr SecretsManager) []core_v1.S
 	return managedSecrets
 }
 
-// BuildK8SSecret builds a k8s secret from a mimir intermediary Secret
-func BuildK8SSecret(secret *Secret, mgr SecretsManager) *core_v1.Secret {
-	data := make(map[string][]byte)
-	for k, v := range secret.Data {
-		data[k] = []byte(v)
+// BuildK8SSecret builds a k8s secret from a mimir intermediary Secret, stamped with a hash of
+// its content. An error is returned if the data does not suit the type of the secret
+func BuildK8SSecret(secret *Secret, mgr SecretsManager) (*core_v1.Secret, error) {
+	secretType, data, err := PrepareK8SSecretData(secret.Type, secret.Data)
+	if err != nil {
+		return nil, err
 	}
-	return &core_v1.Secret{
-		Type: core_v1.SecretTypeOpaque,
+	k8sSecret := &core_v1.Secret{
+		Type: secretType,
 		Data: data,
 		ObjectMeta: meta_v1.ObjectMeta{
 			Name:      secret.Name,
@@ -158,4 +383,45 @@ func BuildK8SSecret(secret *Secret, mgr SecretsManager) *core_v1.Secret {
 			},
 		},
 	}
+	k8sSecret.Annotations[Hash] = hashK8SSecret(k8sSecret)
+	return k8sSecret, nil
+}
+
+// hashK8SSecret provides a hash of the type, data, labels and annotations of a k8s secret. The
+// hash annotation itself is left out, so the hash of a live secret can be compared against the
+// hash stamped on it when it was built
+func hashK8SSecret(secret *core_v1.Secret) string {
+	h := sha256.New()
+	writeField := func(field string) {
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	writeMap := func(values map[string]string) {
+		keys := make([]string, 0)
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeField(strconv.Itoa(len(keys)))
+		for _, k := range keys {
+			writeField(k)
+			writeField(values[k])
+		}
+	}
+
+	data := make(map[string]string)
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	annotations := make(map[string]string)
+	for k, v := range secret.Annotations {
+		if k != Hash {
+			annotations[k] = v
+		}
+	}
+
+	writeField(string(secret.Type))
+	writeMap(data)
+	writeMap(secret.Labels)
+	writeMap(annotations)
+	return hex.EncodeToString(h.Sum(nil))
 }
@@ -1,9 +1,14 @@
 package clients
 
 import (
+	"errors"
 	"testing"
 
 	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
 )
 
 func TestGetManagedSecrets(t *testing.T) {
@@ -16,3 +21,170 @@ func TestGetManagedSecrets(t *testing.T) {
 		t.Error("Secret did not show as managed")
 	}
 }
+
+func TestPlanNamespaceSecrets(t *testing.T) {
+	existing := core_v1.Secret{Data: map[string][]byte{
+		"same":    []byte("mock"),
+		"changed": []byte("old"),
+		"removed": []byte("mock"),
+	}}
+	existing.Name = "existing"
+	stale := core_v1.Secret{Data: map[string][]byte{"mock": []byte("mock")}}
+	stale.Name = "stale"
+
+	nsSecrets := []*Secret{
+		&Secret{Name: "new", Namespace: "mock", Data: map[string]string{"mock": "mock"}},
+		&Secret{Name: "existing", Namespace: "mock", Data: map[string]string{
+			"same":    "mock",
+			"changed": "new",
+			"added":   "mock",
+		}},
+	}
+
+	changes := planNamespaceSecrets("mock", AWS, nsSecrets, []core_v1.Secret{existing, stale})
+	if len(changes) != 3 {
+		t.Fatalf("Expected 3 changes, got %d", len(changes))
+	}
+
+	if changes[0].Action != UpdateSecret || changes[0].Name != "existing" {
+		t.Error("Expected the existing secret to be updated")
+	}
+	if len(changes[0].AddedKeys) != 1 || changes[0].AddedKeys[0] != "added" ||
+		len(changes[0].ChangedKeys) != 1 || changes[0].ChangedKeys[0] != "changed" ||
+		len(changes[0].RemovedKeys) != 1 || changes[0].RemovedKeys[0] != "removed" {
+		t.Error("Key differences for the updated secret were not as expected")
+	}
+
+	if changes[1].Action != CreateSecret || changes[1].Name != "new" {
+		t.Error("Expected the new secret to be created")
+	}
+
+	if changes[2].Action != DeleteSecret || changes[2].Name != "stale" {
+		t.Error("Expected the stale secret to be deleted")
+	}
+}
+
+func TestPlanNamespaceSecretsUnchanged(t *testing.T) {
+	secret := &Secret{Name: "mock", Namespace: "mock", Data: map[string]string{"mock": "mock"}}
+	built, err := BuildK8SSecret(secret, AWS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := *built
+
+	changes := planNamespaceSecrets("mock", AWS, []*Secret{secret}, []core_v1.Secret{existing})
+	if len(changes) != 1 || changes[0].Action != UnchangedSecret {
+		t.Error("Expected the secret to be left unchanged")
+	}
+
+	existing.Data["mock"] = []byte("edited")
+	changes = planNamespaceSecrets("mock", AWS, []*Secret{secret}, []core_v1.Secret{existing})
+	if len(changes) != 1 || changes[0].Action != UpdateSecret {
+		t.Error("Expected a secret edited in the cluster to be updated")
+	}
+	if changes[0].recreate {
+		t.Error("Secret of the same type should be updated in place")
+	}
+
+	tlsSecret := &Secret{Name: "mock", Namespace: "mock", Type: "tls", Data: map[string]string{"tls.crt": "mock", "tls.key": "mock"}}
+	changes = planNamespaceSecrets("mock", AWS, []*Secret{tlsSecret}, []core_v1.Secret{existing})
+	if len(changes) != 1 || changes[0].Action != UpdateSecret || !changes[0].recreate {
+		t.Error("Expected a secret changing type to be recreated")
+	}
+
+	invalid := &Secret{Name: "mock", Namespace: "mock", Type: "tls", Data: map[string]string{"mock": "mock"}}
+	changes = planNamespaceSecrets("mock", AWS, []*Secret{invalid}, []core_v1.Secret{existing})
+	if len(changes) != 0 {
+		t.Error("Expected an invalid secret to be skipped, and not deleted")
+	}
+}
+
+func TestHashK8SSecret(t *testing.T) {
+	secret, err := BuildK8SSecret(&Secret{Name: "mock", Namespace: "mock", Data: map[string]string{"mock": "mock"}}, AWS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Annotations[Hash] != hashK8SSecret(secret) {
+		t.Error("Hash stamped on the secret does not match its content")
+	}
+
+	changedType := secret.DeepCopy()
+	changedType.Type = core_v1.SecretTypeTLS
+	if hashK8SSecret(changedType) == secret.Annotations[Hash] {
+		t.Error("Hash did not change with the secret type")
+	}
+
+	changedMeta := secret.DeepCopy()
+	changedMeta.Annotations[Source] = string(HashicorpVault)
+	if hashK8SSecret(changedMeta) == secret.Annotations[Hash] {
+		t.Error("Hash did not change with the secret annotations")
+	}
+}
+
+func TestRecreateSecret(t *testing.T) {
+	original := &core_v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "mock", Namespace: "mock"},
+		Type:       core_v1.SecretTypeOpaque,
+		Data:       map[string][]byte{"mock": []byte("mock")},
+	}
+	client := fake.NewSimpleClientset(original)
+
+	invalid := &core_v1.Secret{ObjectMeta: original.ObjectMeta, Type: core_v1.SecretTypeTLS, Data: map[string][]byte{core_v1.TLSCertKey: []byte("cert")}}
+	if err := recreateSecret(client, invalid); err == nil {
+		t.Error("Expected an error for a TLS secret without a key")
+	}
+	if secret, err := client.CoreV1().Secrets("mock").Get("mock", meta_v1.GetOptions{}); err != nil || secret.Type != core_v1.SecretTypeOpaque {
+		t.Error("Expected the original secret to be kept when the new data is invalid")
+	}
+
+	client.PrependReactor("create", "secrets", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		if action.(k8s_testing.CreateAction).GetObject().(*core_v1.Secret).Type == core_v1.SecretTypeTLS {
+			return true, nil, errors.New("mock create failure")
+		}
+		return false, nil, nil
+	})
+	tls := &core_v1.Secret{ObjectMeta: original.ObjectMeta, Type: core_v1.SecretTypeTLS, Data: map[string][]byte{
+		core_v1.TLSCertKey:       []byte("cert"),
+		core_v1.TLSPrivateKeyKey: []byte("key"),
+	}}
+	if err := recreateSecret(client, tls); err == nil {
+		t.Error("Expected the create failure to be returned")
+	}
+	secret, err := client.CoreV1().Secrets("mock").Get("mock", meta_v1.GetOptions{})
+	if err != nil || secret.Type != core_v1.SecretTypeOpaque || string(secret.Data["mock"]) != "mock" {
+		t.Error("Expected the original secret to be restored after a failed create")
+	}
+}
+
+func TestPlanNamespaceSecretsLegacySource(t *testing.T) {
+	secret := &Secret{Name: "adopted", Namespace: "mock", Data: map[string]string{"mock": "mock"}}
+	adopted, err := BuildK8SSecret(secret, AWS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := adopted.DeepCopy()
+	legacy.Name = "legacy"
+
+	changes := planNamespaceSecrets("mock", Azure, []*Secret{secret}, []core_v1.Secret{*adopted, *legacy})
+	if len(changes) != 1 {
+		t.Fatalf("Expected a single change, got %d", len(changes))
+	}
+	if changes[0].Action != UpdateSecret || changes[0].Name != "adopted" || changes[0].secret.Annotations[Source] != string(Azure) {
+		t.Error("Expected the secret of the legacy source to be adopted with the new source")
+	}
+}
+
+func TestSkipDeletes(t *testing.T) {
+	plan := &SecretsPlan{Changes: []*SecretChange{
+		&SecretChange{Action: DeleteSecret, Name: "stale", Namespace: "failed"},
+		&SecretChange{Action: CreateSecret, Name: "new", Namespace: "failed"},
+		&SecretChange{Action: DeleteSecret, Name: "stale", Namespace: "mock"},
+	}}
+	plan.SkipDeletes("failed")
+	if len(plan.Changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %d", len(plan.Changes))
+	}
+	if plan.Changes[0].Action != CreateSecret || plan.Changes[1].Namespace != "mock" {
+		t.Error("Only the deletes in the failed namespace should be skipped")
+	}
+}
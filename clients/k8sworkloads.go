@@ -0,0 +1,210 @@
+package clients
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// workload is the common detail needed from a deployment, statefulset or daemonset to decide
+// if it should be restarted, and how to patch it
+type workload struct {
+	Kind        string
+	Name        string
+	Annotations map[string]string
+	Spec        core_v1.PodSpec
+	Patch       func(name string, data []byte) error
+}
+
+// RestartWorkloads triggers a rolling restart of the deployments, statefulsets and daemonsets
+// that consume any secret updated by the plan. A workload consumes a secret if it is referenced
+// by its env, envFrom or volumes, or named in its reload annotation. The workloads of a namespace
+// are listed once, and each is patched once with a checksum of all the updated secrets it
+// consumes, so a workload using several updated secrets is only rolled out a single time.
+func RestartWorkloads(client *kubernetes.Clientset, plan *SecretsPlan) int {
+	updated := make(map[string]map[string]string)
+	for _, change := range plan.Changes {
+		if change.Action != UpdateSecret {
+			continue
+		}
+		if _, ok := updated[change.Namespace]; !ok {
+			updated[change.Namespace] = make(map[string]string)
+		}
+		updated[change.Namespace][change.Name] = change.secret.Annotations[Hash]
+	}
+
+	restarted := 0
+	for namespace, hashes := range updated {
+		workloads, err := listWorkloads(client, namespace)
+		if err != nil {
+			log.Printf("Failed to list workloads in namespace %s: %s\n", namespace, err.Error())
+			continue
+		}
+		for _, wl := range workloads {
+			secretNames := consumedSecrets(wl, hashes)
+			if len(secretNames) == 0 {
+				continue
+			}
+			patch, err := checksumPatch(combinedChecksum(secretNames, hashes))
+			if err != nil {
+				log.Println(err.Error())
+				continue
+			}
+			if err := wl.Patch(wl.Name, patch); err != nil {
+				log.Printf("Failed to restart %s %s in namespace %s: %s\n", wl.Kind, wl.Name, namespace, err.Error())
+				continue
+			}
+			restarted++
+			log.Printf("Restarted %s %s in namespace %s for updated secrets %s\n", wl.Kind, wl.Name, namespace, strings.Join(secretNames, ", "))
+		}
+	}
+	return restarted
+}
+
+// consumedSecrets provides the sorted names of the updated secrets that a workload consumes
+func consumedSecrets(wl workload, hashes map[string]string) []string {
+	secretNames := make([]string, 0)
+	for name := range hashes {
+		if consumesSecret(wl, name) {
+			secretNames = append(secretNames, name)
+		}
+	}
+	sort.Strings(secretNames)
+	return secretNames
+}
+
+// combinedChecksum provides the checksum to patch onto a workload for the updated secrets it
+// consumes. A single secret keeps its own hash, and several are hashed together by name
+func combinedChecksum(secretNames []string, hashes map[string]string) string {
+	if len(secretNames) == 1 {
+		return hashes[secretNames[0]]
+	}
+	hash := sha256.New()
+	for _, name := range secretNames {
+		fmt.Fprintf(hash, "%s=%s\n", name, hashes[name])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// listWorkloads loads all the deployments, statefulsets and daemonsets in a namespace
+func listWorkloads(client *kubernetes.Clientset, namespace string) ([]workload, error) {
+	workloads := make([]workload, 0)
+
+	deployments, err := client.AppsV1().Deployments(namespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, deployment := range deployments.Items {
+		workloads = append(workloads, workload{
+			Kind:        "deployment",
+			Name:        deployment.Name,
+			Annotations: deployment.Annotations,
+			Spec:        deployment.Spec.Template.Spec,
+			Patch: func(name string, data []byte) error {
+				_, err := client.AppsV1().Deployments(namespace).Patch(name, types.StrategicMergePatchType, data)
+				return err
+			},
+		})
+	}
+
+	statefulSets, err := client.AppsV1().StatefulSets(namespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, statefulSet := range statefulSets.Items {
+		workloads = append(workloads, workload{
+			Kind:        "statefulset",
+			Name:        statefulSet.Name,
+			Annotations: statefulSet.Annotations,
+			Spec:        statefulSet.Spec.Template.Spec,
+			Patch: func(name string, data []byte) error {
+				_, err := client.AppsV1().StatefulSets(namespace).Patch(name, types.StrategicMergePatchType, data)
+				return err
+			},
+		})
+	}
+
+	daemonSets, err := client.AppsV1().DaemonSets(namespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, daemonSet := range daemonSets.Items {
+		workloads = append(workloads, workload{
+			Kind:        "daemonset",
+			Name:        daemonSet.Name,
+			Annotations: daemonSet.Annotations,
+			Spec:        daemonSet.Spec.Template.Spec,
+			Patch: func(name string, data []byte) error {
+				_, err := client.AppsV1().DaemonSets(namespace).Patch(name, types.StrategicMergePatchType, data)
+				return err
+			},
+		})
+	}
+
+	return workloads, nil
+}
+
+// checksumPatch builds a strategic merge patch setting the checksum annotation on a pod template
+func checksumPatch(checksum string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{Checksum: checksum},
+				},
+			},
+		},
+	})
+}
+
+// consumesSecret determines if a workload uses the named secret, or asks to be reloaded with it
+func consumesSecret(wl workload, secretName string) bool {
+	if reload, ok := wl.Annotations[Reload]; ok {
+		for _, name := range strings.Split(reload, ",") {
+			if strings.TrimSpace(name) == secretName {
+				return true
+			}
+		}
+	}
+	return podSpecUsesSecret(wl.Spec, secretName)
+}
+
+// podSpecUsesSecret determines if any volume or container env in a pod spec references the secret
+func podSpecUsesSecret(spec core_v1.PodSpec, secretName string) bool {
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == secretName {
+			return true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil && source.Secret.Name == secretName {
+					return true
+				}
+			}
+		}
+	}
+
+	containers := append(append([]core_v1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil && envFrom.SecretRef.Name == secretName {
+				return true
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == secretName {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package clients
+
+import (
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+func TestPodSpecUsesSecret(t *testing.T) {
+	volumeSpec := core_v1.PodSpec{
+		Volumes: []core_v1.Volume{
+			core_v1.Volume{
+				Name: "mock",
+				VolumeSource: core_v1.VolumeSource{
+					Secret: &core_v1.SecretVolumeSource{SecretName: "mockvolume"},
+				},
+			},
+		},
+	}
+	if !podSpecUsesSecret(volumeSpec, "mockvolume") {
+		t.Error("Did not detect the secret used as a volume")
+	}
+
+	envRef := core_v1.SecretKeySelector{Key: "mock"}
+	envRef.Name = "mockenv"
+	envFromRef := &core_v1.SecretEnvSource{}
+	envFromRef.Name = "mockenvfrom"
+	envSpec := core_v1.PodSpec{
+		InitContainers: []core_v1.Container{
+			core_v1.Container{
+				Env: []core_v1.EnvVar{
+					core_v1.EnvVar{Name: "mock", ValueFrom: &core_v1.EnvVarSource{SecretKeyRef: &envRef}},
+				},
+			},
+		},
+		Containers: []core_v1.Container{
+			core_v1.Container{
+				EnvFrom: []core_v1.EnvFromSource{
+					core_v1.EnvFromSource{SecretRef: envFromRef},
+				},
+			},
+		},
+	}
+	if !podSpecUsesSecret(envSpec, "mockenv") {
+		t.Error("Did not detect the secret used as an env var")
+	}
+	if !podSpecUsesSecret(envSpec, "mockenvfrom") {
+		t.Error("Did not detect the secret used by envFrom")
+	}
+	if podSpecUsesSecret(envSpec, "mockvolume") {
+		t.Error("Detected a secret that is not used")
+	}
+}
+
+func TestConsumesSecret(t *testing.T) {
+	wl := workload{Annotations: map[string]string{Reload: "mock1, mock2"}}
+	if !consumesSecret(wl, "mock2") {
+		t.Error("Did not detect the secret in the reload annotation")
+	}
+	if consumesSecret(wl, "mock3") {
+		t.Error("Detected a secret that is not in the reload annotation")
+	}
+}
+
+func TestCombinedChecksum(t *testing.T) {
+	hashes := map[string]string{"mock1": "hash1", "mock2": "hash2", "mock3": "hash3"}
+	wl := workload{Annotations: map[string]string{Reload: "mock2, mock1"}}
+
+	secretNames := consumedSecrets(wl, hashes)
+	if len(secretNames) != 2 || secretNames[0] != "mock1" || secretNames[1] != "mock2" {
+		t.Fatalf("Expected the consumed secrets sorted by name, got %v", secretNames)
+	}
+	combined := combinedChecksum(secretNames, hashes)
+	if combined == "hash1" || combined == "hash2" {
+		t.Error("Expected the checksum to combine every consumed secret")
+	}
+	hashes["mock2"] = "hash2-rotated"
+	if combinedChecksum(secretNames, hashes) == combined {
+		t.Error("Expected the checksum to change with the hash of any consumed secret")
+	}
+	if combinedChecksum([]string{"mock3"}, hashes) != "hash3" {
+		t.Error("Expected a single secret to keep its own hash")
+	}
+}
@@ -0,0 +1,99 @@
+package clients
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	backendRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mimir_backend_requests_total",
+		Help: "Number of requests made to the secrets manager backend, by operation and result",
+	}, []string{"backend", "operation", "result"})
+	backendLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mimir_backend_request_duration_seconds",
+		Help:    "Time taken by requests to the secrets manager backend",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"backend", "operation"})
+	backendLogins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mimir_backend_logins_total",
+		Help: "Number of attempts to load an authenticated client for the secrets manager backend, by result",
+	}, []string{"backend", "result"})
+	secretChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mimir_secrets_total",
+		Help: "Number of secrets managed in kubernetes, by the action taken on them",
+	}, []string{"backend", "action"})
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mimir_cache_lookups_total",
+		Help: "Number of secrets looked up in the webhook cache, by whether they were a hit or a miss",
+	}, []string{"result"})
+	vaultTokenRenewals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mimir_vault_token_renewals_total",
+		Help: "Number of attempts to renew the Hashicorp Vault token, by result",
+	}, []string{"result"})
+	vaultTokenLogins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mimir_vault_token_logins_total",
+		Help: "Number of attempts to log in to Hashicorp Vault again once the token could not be renewed, by result",
+	}, []string{"result"})
+	vaultTokenExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mimir_vault_token_expiry_timestamp_seconds",
+		Help: "Unix time at which the current Hashicorp Vault token expires",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(backendRequests, backendLatency, backendLogins, secretChanges, cacheLookups, vaultTokenRenewals, vaultTokenLogins, vaultTokenExpiry)
+}
+
+// resultLabel converts an error into the result label used by metrics
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// RecordBackendLogin records the result of loading an authenticated client for a backend
+func RecordBackendLogin(mgr SecretsManager, err error) {
+	backendLogins.WithLabelValues(string(mgr), resultLabel(err)).Inc()
+}
+
+// instrumentedClient wraps a SecretsManagerClient, recording metrics for every call to the backend
+type instrumentedClient struct {
+	SecretsManagerClient
+	mgr SecretsManager
+}
+
+// NewInstrumentedClient wraps a SecretsManagerClient so that calls made to the backend through it
+// are counted and timed
+func NewInstrumentedClient(client SecretsManagerClient, mgr SecretsManager) SecretsManagerClient {
+	return &instrumentedClient{client, mgr}
+}
+
+// Close stops any background work of the wrapped client
+func (client instrumentedClient) Close() {
+	closeClient(client.SecretsManagerClient)
+}
+
+// GetSecrets calls the wrapped client, recording the call
+func (client instrumentedClient) GetSecrets(namespaces ...string) ([]*Secret, error) {
+	start := time.Now()
+	secrets, err := client.SecretsManagerClient.GetSecrets(namespaces...)
+	client.record("GetSecrets", start, err)
+	return secrets, err
+}
+
+// GetSecret calls the wrapped client, recording the call
+func (client instrumentedClient) GetSecret(path string) (*Secret, error) {
+	start := time.Now()
+	secret, err := client.SecretsManagerClient.GetSecret(path)
+	client.record("GetSecret", start, err)
+	return secret, err
+}
+
+// record adds the result and duration of a single call to the backend metrics
+func (client instrumentedClient) record(operation string, start time.Time, err error) {
+	backendLatency.WithLabelValues(string(client.mgr), operation).Observe(time.Since(start).Seconds())
+	backendRequests.WithLabelValues(string(client.mgr), operation, resultLabel(err)).Inc()
+}
@@ -0,0 +1,39 @@
+package clients
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type mockSecretsManagerClient struct {
+	err error
+}
+
+func (client mockSecretsManagerClient) GetSecrets(namespaces ...string) ([]*Secret, error) {
+	return []*Secret{}, client.err
+}
+
+func (client mockSecretsManagerClient) GetSecret(path string) (*Secret, error) {
+	return &Secret{Name: path}, client.err
+}
+
+func TestInstrumentedClient(t *testing.T) {
+	client := NewInstrumentedClient(mockSecretsManagerClient{}, "mock")
+	client.GetSecrets("mock")
+	client.GetSecret("mock")
+
+	if count := testutil.ToFloat64(backendRequests.WithLabelValues("mock", "GetSecrets", "success")); count != 1 {
+		t.Errorf("Expected 1 successful GetSecrets call, got %v", count)
+	}
+
+	failing := NewInstrumentedClient(mockSecretsManagerClient{err: errors.New("mock")}, "mock")
+	if _, err := failing.GetSecret("mock"); err == nil {
+		t.Error("Expected the error from the wrapped client")
+	}
+
+	if count := testutil.ToFloat64(backendRequests.WithLabelValues("mock", "GetSecret", "error")); count != 1 {
+		t.Errorf("Expected 1 failed GetSecret call, got %v", count)
+	}
+}
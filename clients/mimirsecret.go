@@ -0,0 +1,271 @@
+package clients
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// MimirSecretGroup is the api group of the MimirSecret custom resource
+	MimirSecretGroup = "mimir.marmotherder.io"
+	// MimirSecretVersion is the served version of the MimirSecret custom resource
+	MimirSecretVersion = "v1alpha1"
+	// MimirSecretKind is the kind of the MimirSecret custom resource
+	MimirSecretKind = "MimirSecret"
+	// ReadyCondition is the status condition set on a MimirSecret after each reconcile
+	ReadyCondition = "Ready"
+)
+
+// MimirSecretResource is used to load MimirSecret resources via the dynamic client
+var MimirSecretResource = schema.GroupVersionResource{Group: MimirSecretGroup, Version: MimirSecretVersion, Resource: "mimirsecrets"}
+
+// MimirSecret declares a k8s secret that should be kept in line with a remote secret
+type MimirSecret struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+	Spec               MimirSecretSpec   `json:"spec"`
+	Status             MimirSecretStatus `json:"status,omitempty"`
+}
+
+// MimirSecretSpec is the desired state of a MimirSecret
+type MimirSecretSpec struct {
+	// Remote is the path/name of the secret in the backend, as used by the webhook
+	Remote string `json:"remote"`
+	// Backend limits the resource to the controller for one secrets manager. When empty,
+	// any controller will reconcile it
+	Backend SecretsManager `json:"backend,omitempty"`
+	// Target is the name of the k8s secret to manage, defaulting to the name of the resource
+	Target string `json:"target,omitempty"`
+	// Type is the k8s secret type, or an alias of it such as tls, defaulting to the type set on
+	// the remote secret, or Opaque
+	Type string `json:"type,omitempty"`
+	// Keys maps remote keys to the keys in the k8s secret. When set, only mapped keys are kept
+	Keys map[string]string `json:"keys,omitempty"`
+	// RefreshInterval is how often the secret is reloaded from the backend, eg. 1h
+	RefreshInterval string `json:"refreshInterval,omitempty"`
+}
+
+// MimirSecretStatus is the observed state of a MimirSecret
+type MimirSecretStatus struct {
+	Conditions         []MimirSecretCondition `json:"conditions,omitempty"`
+	LastSyncTime       *meta_v1.Time          `json:"lastSyncTime,omitempty"`
+	ObservedGeneration int64                  `json:"observedGeneration,omitempty"`
+}
+
+// MimirSecretCondition is a single status condition of a MimirSecret
+type MimirSecretCondition struct {
+	Type               string                  `json:"type"`
+	Status             core_v1.ConditionStatus `json:"status"`
+	Reason             string                  `json:"reason,omitempty"`
+	Message            string                  `json:"message,omitempty"`
+	LastTransitionTime meta_v1.Time            `json:"lastTransitionTime,omitempty"`
+}
+
+// TargetName is the name of the k8s secret managed for the resource
+func (ms *MimirSecret) TargetName() string {
+	if ms.Spec.Target != "" {
+		return ms.Spec.Target
+	}
+	return ms.Name
+}
+
+// IsReady reports whether the last reconcile of the resource succeeded
+func (ms *MimirSecret) IsReady() bool {
+	for _, condition := range ms.Status.Conditions {
+		if condition.Type == ReadyCondition {
+			return condition.Status == core_v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// IsDue reports whether the resource should be reconciled. It is due when it has never been
+// synced, has changed since it was last synced, is not ready, or its refresh interval has passed
+func (ms *MimirSecret) IsDue(now time.Time, defaultInterval time.Duration) (bool, error) {
+	if ms.Status.LastSyncTime == nil || ms.Status.ObservedGeneration != ms.Generation || !ms.IsReady() {
+		return true, nil
+	}
+	interval := defaultInterval
+	if ms.Spec.RefreshInterval != "" {
+		var err error
+		interval, err = time.ParseDuration(ms.Spec.RefreshInterval)
+		if err != nil {
+			return false, fmt.Errorf("Invalid refreshInterval %s: %s", ms.Spec.RefreshInterval, err.Error())
+		}
+	}
+	return !now.Before(ms.Status.LastSyncTime.Add(interval)), nil
+}
+
+// SetCondition sets a status condition on the resource, only moving the transition time on
+// when the status of the condition changes
+func (ms *MimirSecret) SetCondition(conditionType string, status core_v1.ConditionStatus, reason, message string, now time.Time) {
+	condition := MimirSecretCondition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: meta_v1.NewTime(now),
+	}
+	for idx, existing := range ms.Status.Conditions {
+		if existing.Type == conditionType {
+			if existing.Status == status {
+				condition.LastTransitionTime = existing.LastTransitionTime
+			}
+			ms.Status.Conditions[idx] = condition
+			return
+		}
+	}
+	ms.Status.Conditions = append(ms.Status.Conditions, condition)
+}
+
+// ListMimirSecrets loads the MimirSecret resources from every namespace in the cluster
+func ListMimirSecrets(client dynamic.Interface) ([]*MimirSecret, error) {
+	list, err := client.Resource(MimirSecretResource).List(meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	mimirSecrets := make([]*MimirSecret, 0)
+	for _, item := range list.Items {
+		ms := &MimirSecret{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.UnstructuredContent(), ms); err != nil {
+			log.Printf("Failed to read MimirSecret %s in namespace %s: %s\n", item.GetName(), item.GetNamespace(), err.Error())
+			continue
+		}
+		mimirSecrets = append(mimirSecrets, ms)
+	}
+	return mimirSecrets, nil
+}
+
+// UpdateMimirSecretStatus writes the status of the resource back to the cluster
+func UpdateMimirSecretStatus(client dynamic.Interface, ms *MimirSecret) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(ms)
+	if err != nil {
+		return err
+	}
+	_, err = client.Resource(MimirSecretResource).Namespace(ms.Namespace).UpdateStatus(&unstructured.Unstructured{Object: content}, meta_v1.UpdateOptions{})
+	return err
+}
+
+// ReconcileMimirSecret loads the remote secret for the resource from the backend, and creates
+// or updates the k8s secret it declares. The secret is owned by the resource, so is removed by
+// kubernetes when the resource is deleted
+func ReconcileMimirSecret(client *kubernetes.Clientset, smc SecretsManagerClient, mgr SecretsManager, ms *MimirSecret) (SecretAction, error) {
+	secret, err := smc.GetSecret(ms.Spec.Remote)
+	if err != nil {
+		return "", err
+	}
+	k8sSecret, err := BuildMimirK8SSecret(ms, secret, mgr)
+	if err != nil {
+		return "", err
+	}
+
+	action := CreateSecret
+	existing, err := client.CoreV1().Secrets(ms.Namespace).Get(k8sSecret.Name, meta_v1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	if err == nil {
+		if !isOwnedBy(existing, ms) {
+			return "", fmt.Errorf("Secret %s already exists in namespace %s and is not owned by MimirSecret %s", existing.Name, ms.Namespace, ms.Name)
+		}
+		hash := k8sSecret.Annotations[Hash]
+		if existing.Annotations[Hash] == hash && hashK8SSecret(existing) == hash {
+			action = UnchangedSecret
+		} else {
+			action = UpdateSecret
+		}
+	}
+
+	switch {
+	case action == CreateSecret:
+		_, err = client.CoreV1().Secrets(ms.Namespace).Create(k8sSecret)
+	case action == UpdateSecret && existing.Type != k8sSecret.Type:
+		err = recreateSecret(client, k8sSecret)
+	case action == UpdateSecret:
+		k8sSecret.ResourceVersion = existing.ResourceVersion
+		_, err = client.CoreV1().Secrets(ms.Namespace).Update(k8sSecret)
+	}
+	if err != nil {
+		return "", err
+	}
+	secretChanges.WithLabelValues(string(mgr), string(action)).Inc()
+	return action, nil
+}
+
+// BuildMimirK8SSecret builds the k8s secret declared by a MimirSecret from the remote secret,
+// applying the key mapping of the resource
+func BuildMimirK8SSecret(ms *MimirSecret, secret *Secret, mgr SecretsManager) (*core_v1.Secret, error) {
+	// The type is taken before the keys are mapped, as a mimir-type key would be left out
+	secretType := ms.Spec.Type
+	if secretType == "" {
+		secretType = secret.Type
+	}
+	if secretType == "" {
+		secretType = secret.Data[Type]
+	}
+
+	mapped := secret.Data
+	if len(ms.Spec.Keys) > 0 {
+		mapped = make(map[string]string)
+		for remoteKey, localKey := range ms.Spec.Keys {
+			v, ok := secret.Data[remoteKey]
+			if !ok {
+				return nil, fmt.Errorf("Key %s was not found in remote secret %s", remoteKey, ms.Spec.Remote)
+			}
+			if localKey == "" {
+				localKey = remoteKey
+			}
+			mapped[localKey] = v
+		}
+	}
+
+	k8sType, data, err := PrepareK8SSecretData(secretType, mapped)
+	if err != nil {
+		return nil, err
+	}
+
+	controller := true
+	k8sSecret := &core_v1.Secret{
+		Type: k8sType,
+		Data: data,
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      ms.TargetName(),
+			Namespace: ms.Namespace,
+			Annotations: map[string]string{
+				Owner:  ms.Name,
+				Source: string(mgr),
+			},
+			OwnerReferences: []meta_v1.OwnerReference{
+				meta_v1.OwnerReference{
+					APIVersion: fmt.Sprintf("%s/%s", MimirSecretGroup, MimirSecretVersion),
+					Kind:       MimirSecretKind,
+					Name:       ms.Name,
+					UID:        ms.UID,
+					Controller: &controller,
+				},
+			},
+		},
+	}
+	k8sSecret.Annotations[Hash] = hashK8SSecret(k8sSecret)
+	return k8sSecret, nil
+}
+
+// isOwnedBy checks if a k8s secret is controlled by the given MimirSecret
+func isOwnedBy(secret *core_v1.Secret, ms *MimirSecret) bool {
+	for _, ref := range secret.OwnerReferences {
+		if ref.Kind == MimirSecretKind && ref.UID == ms.UID {
+			return true
+		}
+	}
+	return false
+}
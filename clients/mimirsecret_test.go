@@ -0,0 +1,97 @@
+package clients
+
+import (
+	"testing"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildMimirK8SSecret(t *testing.T) {
+	ms := &MimirSecret{Spec: MimirSecretSpec{Remote: "mock"}}
+	ms.Name = "mock"
+	ms.Namespace = "mock"
+	ms.UID = "mock-uid"
+	secret := &Secret{Data: map[string]string{"user": "mock", "pass": "mock", "unused": "mock"}}
+
+	k8sSecret, err := BuildMimirK8SSecret(ms, secret, AWS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k8sSecret.Name != "mock" || k8sSecret.Type != core_v1.SecretTypeOpaque || len(k8sSecret.Data) != 3 {
+		t.Error("Secret was not built with the defaults of the resource")
+	}
+	if k8sSecret.Annotations[Owner] != "mock" || k8sSecret.Annotations[Managed] != "" {
+		t.Error("Secret should be annotated with its owner and not as managed")
+	}
+	if !isOwnedBy(k8sSecret, ms) {
+		t.Error("Secret should carry an owner reference to the resource")
+	}
+
+	ms.Spec.Target = "target"
+	ms.Spec.Keys = map[string]string{"user": "username", "pass": ""}
+	k8sSecret, err = BuildMimirK8SSecret(ms, secret, AWS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k8sSecret.Name != "target" {
+		t.Error("Secret should be named after the target")
+	}
+	if len(k8sSecret.Data) != 2 || string(k8sSecret.Data["username"]) != "mock" || string(k8sSecret.Data["pass"]) != "mock" {
+		t.Error("Only the mapped keys should be in the secret")
+	}
+
+	ms.Spec.Keys = map[string]string{"missing": "missing"}
+	if _, err := BuildMimirK8SSecret(ms, secret, AWS); err == nil {
+		t.Error("Expected an error for a mapped key missing from the remote secret")
+	}
+}
+
+func TestMimirSecretIsDue(t *testing.T) {
+	now := time.Now()
+	ms := &MimirSecret{}
+	if due, _ := ms.IsDue(now, time.Minute); !due {
+		t.Error("A resource that has never synced should be due")
+	}
+
+	ms.Status.LastSyncTime = &meta_v1.Time{Time: now.Add(-30 * time.Second)}
+	ms.SetCondition(ReadyCondition, core_v1.ConditionTrue, "Synced", "", now)
+	if due, _ := ms.IsDue(now, time.Minute); due {
+		t.Error("A ready resource within its interval should not be due")
+	}
+
+	ms.Spec.RefreshInterval = "10s"
+	if due, _ := ms.IsDue(now, time.Minute); !due {
+		t.Error("The refresh interval of the resource should override the default")
+	}
+
+	ms.Spec.RefreshInterval = "mock"
+	if _, err := ms.IsDue(now, time.Minute); err == nil {
+		t.Error("Expected an error for an invalid refresh interval")
+	}
+
+	ms.Spec.RefreshInterval = ""
+	ms.Generation = 2
+	if due, _ := ms.IsDue(now, time.Minute); !due {
+		t.Error("A changed resource should be due")
+	}
+}
+
+func TestMimirSecretSetCondition(t *testing.T) {
+	first := time.Now()
+	ms := &MimirSecret{}
+	ms.SetCondition(ReadyCondition, core_v1.ConditionFalse, "Error", "mock", first)
+	ms.SetCondition(ReadyCondition, core_v1.ConditionFalse, "Error", "mock", first.Add(time.Minute))
+	if len(ms.Status.Conditions) != 1 || !ms.Status.Conditions[0].LastTransitionTime.Time.Equal(first) {
+		t.Error("Transition time should not move when the status is unchanged")
+	}
+	if ms.IsReady() {
+		t.Error("Resource should not be ready")
+	}
+
+	ms.SetCondition(ReadyCondition, core_v1.ConditionTrue, "Synced", "", first.Add(time.Minute))
+	if !ms.IsReady() || ms.Status.Conditions[0].LastTransitionTime.Time.Equal(first) {
+		t.Error("Resource should be ready with a new transition time")
+	}
+}
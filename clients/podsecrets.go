@@ -0,0 +1,290 @@
+package clients
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PodSecret is a remote secret that the annotations of a pod ask the webhook to inject
+type PodSecret struct {
+	// ID names an indexed secret by the suffix of its annotations, eg. db for mimir-remote.db. It is
+	// empty for the secret set by the plain annotations
+	ID string
+	// Remote is the path/name of the secret in the backend
+	Remote string
+	// Path is where the secret is mounted in the containers, empty when it is not mounted
+	Path string
+	// Local overrides the name of the generated secret
+	Local string
+	// Type is the k8s secret type, or an alias of it
+	Type string
+	// Version selects the version of an AWS secret, either a staging label or a version ID
+	Version string
+	// Env injects the keys of the secret into the containers as env vars
+	Env bool
+	// EnvInjection selects and names the env vars
+	EnvInjection *EnvInjection
+	// Containers selects the containers the secret is injected into
+	Containers *ContainerFilter
+}
+
+// ParsePodSecrets reads the secrets to inject from the annotations of a pod, sorted by ID. As well
+// as the secret set by the plain annotations, each mimir-remote.<id> annotation adds a secret that
+// is configured by the annotations with the same .<id> suffix, eg. mimir-path.<id>
+func ParsePodSecrets(annotations map[string]string) ([]*PodSecret, error) {
+	ids := make([]string, 0)
+	if _, ok := annotations[Remote]; ok {
+		ids = append(ids, "")
+	}
+	for k := range annotations {
+		if !strings.HasPrefix(k, Remote+".") {
+			continue
+		}
+		id := strings.TrimPrefix(k, Remote+".")
+		if errs := validation.IsDNS1123Label(id); len(errs) > 0 {
+			return nil, fmt.Errorf("Invalid secret id %s in annotation %s: %s", id, k, strings.Join(errs, ", "))
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	podSecrets := make([]*PodSecret, 0)
+	for _, id := range ids {
+		podSecret, err := parsePodSecret(id, indexedAnnotations(annotations, id))
+		if err != nil {
+			return nil, err
+		}
+		podSecrets = append(podSecrets, podSecret)
+	}
+	return podSecrets, nil
+}
+
+// SecretName is the name of the k8s secret generated for the pod. Indexed secrets have their ID
+// appended, unless they have a local name
+func (podSecret *PodSecret) SecretName(release, podName string) string {
+	if podSecret.Local != "" {
+		return fmt.Sprintf("%s-%s", release, podSecret.Local)
+	}
+	if podSecret.ID != "" {
+		return fmt.Sprintf("%s-%s-%s", release, podName, podSecret.ID)
+	}
+	return fmt.Sprintf("%s-%s", release, podName)
+}
+
+// RemotePath is the path the secret is loaded from in the backend, with any version added after a #.
+// Versions can only be selected from AWS Secrets Manager, so an error is returned for other backends
+func (podSecret *PodSecret) RemotePath(mgr SecretsManager) (string, error) {
+	if podSecret.Version == "" {
+		return podSecret.Remote, nil
+	}
+	if mgr != AWS {
+		return "", fmt.Errorf("The annotation %s is only supported by the %s backend, not %s", indexedAnnotation(Version, podSecret.ID), AWS, mgr)
+	}
+	return fmt.Sprintf("%s#%s", podSecret.Remote, podSecret.Version), nil
+}
+
+// parsePodSecret reads a single secret from annotations that have had any index removed
+func parsePodSecret(id string, annotations map[string]string) (*PodSecret, error) {
+	podSecret := &PodSecret{
+		ID:      id,
+		Remote:  annotations[Remote],
+		Path:    annotations[Path],
+		Local:   annotations[Local],
+		Type:    annotations[Type],
+		Version: annotations[Version],
+	}
+	if podSecret.Remote == "" {
+		return nil, fmt.Errorf("Missing properties for remote secret name in annotation %s", indexedAnnotation(Remote, id))
+	}
+	podSecret.Env, _ = strconv.ParseBool(annotations[Env])
+
+	var err error
+	podSecret.EnvInjection, err = ParseEnvInjection(annotations)
+	if err != nil {
+		return nil, err
+	}
+	podSecret.Containers = ParseContainerFilter(annotations)
+	return podSecret, nil
+}
+
+// indexedAnnotations provides the annotations with the .<id> suffix, with the suffix removed so
+// they can be read as plain annotations. An empty id gives back the annotations as they are
+func indexedAnnotations(annotations map[string]string, id string) map[string]string {
+	if id == "" {
+		return annotations
+	}
+	indexed := make(map[string]string)
+	for k, v := range annotations {
+		if strings.HasSuffix(k, "."+id) {
+			indexed[strings.TrimSuffix(k, "."+id)] = v
+		}
+	}
+	return indexed
+}
+
+// indexedAnnotation is the name of an annotation for the secret with the given id
+func indexedAnnotation(annotation, id string) string {
+	if id == "" {
+		return annotation
+	}
+	return fmt.Sprintf("%s.%s", annotation, id)
+}
+
+// PodSecretCollection is the plan for a garbage collection of the secrets generated by the webhook
+type PodSecretCollection struct {
+	// Orphaned are the secrets whose pod no longer exists
+	Orphaned []core_v1.Secret
+	// Adopted maps the names of secrets to the pods using them that they have no owner reference to
+	Adopted map[string][]core_v1.Pod
+}
+
+// CollectPodSecrets removes the secrets generated by the hook whose pod no longer exists, which
+// happens when the webhook misses the pod being deleted. Secrets younger than the grace period are
+// left, as they are created before their pod. The secrets of pods that do exist are given owner
+// references to the pods, so kubernetes removes them along with the last pod using them. Only the
+// secrets labelled with the hook are listed, unless legacy is set, when every secret is listed so
+// those generated before the hook labelled them are found and labelled as they are collected.
+func CollectPodSecrets(client *kubernetes.Clientset, hook, release string, gracePeriod time.Duration, legacy bool) (deleted int, adopted int, err error) {
+	listOpts := meta_v1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", Hook, hook)}
+	if legacy {
+		listOpts = meta_v1.ListOptions{}
+	}
+	secrets, err := client.CoreV1().Secrets("").List(listOpts)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	byNamespace := make(map[string][]core_v1.Secret)
+	for _, secret := range hookSecrets(secrets.Items, hook, release) {
+		byNamespace[secret.Namespace] = append(byNamespace[secret.Namespace], secret)
+	}
+
+	for namespace, nsSecrets := range byNamespace {
+		pods, err := client.CoreV1().Pods(namespace).List(meta_v1.ListOptions{})
+		if err != nil {
+			log.Printf("Failed to list pods in namespace %s: %s\n", namespace, err.Error())
+			continue
+		}
+
+		collection := planPodSecretCollection(nsSecrets, pods.Items, time.Now(), gracePeriod)
+		orphaned := make(map[string]bool)
+		for _, secret := range collection.Orphaned {
+			orphaned[secret.Name] = true
+			// The uid precondition stops a secret regenerated since it was listed from being removed
+			uid := secret.UID
+			if err := client.CoreV1().Secrets(namespace).Delete(secret.Name, &meta_v1.DeleteOptions{Preconditions: &meta_v1.Preconditions{UID: &uid}}); err != nil {
+				log.Printf("Failed to delete orphaned secret %s in namespace %s: %s\n", secret.Name, namespace, err.Error())
+				continue
+			}
+			deleted++
+			log.Printf("Deleted orphaned secret %s in namespace %s\n", secret.Name, namespace)
+		}
+		for _, secret := range nsSecrets {
+			owners, ok := collection.Adopted[secret.Name]
+			unlabelled := secret.Labels[Hook] != hook
+			if (!ok && !unlabelled) || orphaned[secret.Name] {
+				continue
+			}
+			if unlabelled {
+				if secret.Labels == nil {
+					secret.Labels = make(map[string]string)
+				}
+				secret.Labels[Hook] = hook
+			}
+			for idx := range owners {
+				secret.OwnerReferences = append(secret.OwnerReferences, PodOwnerReference(&owners[idx]))
+			}
+			if _, err := client.CoreV1().Secrets(namespace).Update(&secret); err != nil {
+				log.Printf("Failed to set the owners of secret %s in namespace %s: %s\n", secret.Name, namespace, err.Error())
+				continue
+			}
+			if ok {
+				adopted++
+			}
+		}
+	}
+	return deleted, adopted, nil
+}
+
+// hookSecrets selects the secrets generated by the hook. These are labelled with the hook, or for
+// secrets generated before the label was added, annotated with it and named after the release
+func hookSecrets(secrets []core_v1.Secret, hook, release string) []core_v1.Secret {
+	selected := make([]core_v1.Secret, 0)
+	for _, secret := range secrets {
+		if _, labelled := secret.Labels[Hook]; labelled {
+			if secret.Labels[Hook] == hook {
+				selected = append(selected, secret)
+			}
+			continue
+		}
+		if secret.Annotations[Hook] == hook && strings.HasPrefix(secret.Name, release+"-") {
+			selected = append(selected, secret)
+		}
+	}
+	return selected
+}
+
+// PodOwnerReference is the owner reference making a secret be removed along with the pod
+func PodOwnerReference(pod *core_v1.Pod) meta_v1.OwnerReference {
+	return meta_v1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}
+}
+
+// planPodSecretCollection works out which of the secrets in a namespace are orphaned, and which
+// should be given an owner reference to the pod using them. A pod uses a secret if it is listed
+// in the secrets annotation of the pod, or referenced by its volumes or env
+func planPodSecretCollection(secrets []core_v1.Secret, pods []core_v1.Pod, now time.Time, gracePeriod time.Duration) *PodSecretCollection {
+	collection := &PodSecretCollection{
+		Orphaned: make([]core_v1.Secret, 0),
+		Adopted:  make(map[string][]core_v1.Pod),
+	}
+
+	for _, secret := range secrets {
+		owned := make(map[types.UID]bool)
+		for _, ref := range secret.OwnerReferences {
+			if ref.Kind == "Pod" {
+				owned[ref.UID] = true
+			}
+		}
+
+		used := false
+		for _, pod := range pods {
+			if !podUsesSecret(pod, secret.Name) {
+				continue
+			}
+			used = true
+			if !owned[pod.UID] {
+				collection.Adopted[secret.Name] = append(collection.Adopted[secret.Name], pod)
+			}
+		}
+
+		if !used && now.Sub(secret.CreationTimestamp.Time) >= gracePeriod {
+			collection.Orphaned = append(collection.Orphaned, secret)
+		}
+	}
+	return collection
+}
+
+// podUsesSecret determines if the secret was generated for a pod, or is otherwise referenced by it
+func podUsesSecret(pod core_v1.Pod, secretName string) bool {
+	for _, name := range strings.Split(pod.Annotations[Secrets], ",") {
+		if strings.TrimSpace(name) == secretName {
+			return true
+		}
+	}
+	return podSpecUsesSecret(pod.Spec, secretName)
+}
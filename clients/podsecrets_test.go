@@ -0,0 +1,126 @@
+package clients
+
+import (
+	"testing"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestParsePodSecrets(t *testing.T) {
+	podSecrets, err := ParsePodSecrets(map[string]string{
+		Remote:                  "mock",
+		Env:                     "true",
+		Remote + ".db":          "db",
+		Path + ".db":            "/etc/db",
+		EnvKeys + ".db":         "user=DB_USER",
+		Version + ".db":         "AWSPENDING",
+		Containers + ".db":      "app",
+		Remote + ".api":         "api",
+		Local + ".api":          "api-key",
+		Type + ".api":           "basic-auth",
+		Env + ".api":            "true",
+		EnvPrefix + ".api":      "API_",
+		"mimir-unrelated.other": "mock",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podSecrets) != 3 || podSecrets[0].ID != "" || podSecrets[1].ID != "api" || podSecrets[2].ID != "db" {
+		t.Fatal("Secrets were not parsed and sorted by id")
+	}
+
+	plain, api, db := podSecrets[0], podSecrets[1], podSecrets[2]
+	if plain.Remote != "mock" || !plain.Env || plain.Path != "" {
+		t.Error("Plain annotations were not parsed as expected")
+	}
+	if api.Remote != "api" || api.Type != "basic-auth" || !api.Env || api.EnvInjection.Prefix != "API_" {
+		t.Error("Indexed annotations were not parsed as expected")
+	}
+	if path, err := plain.RemotePath(HashicorpVault); err != nil || path != "mock" {
+		t.Error("The remote path of a secret without a version should be left as it is")
+	}
+	if path, err := db.RemotePath(AWS); err != nil || path != "db#AWSPENDING" {
+		t.Error("The version was not added to the remote path")
+	}
+	if _, err := db.RemotePath(GCP); err == nil {
+		t.Error("Expected an error for a version with a backend other than aws")
+	}
+	if db.Path != "/etc/db" || db.Env || db.EnvInjection.Keys["user"] != "DB_USER" || db.Containers.Matches("sidecar") {
+		t.Error("Indexed annotations should not be mixed with those of other secrets")
+	}
+
+	if plain.SecretName("mimir", "pod") != "mimir-pod" || api.SecretName("mimir", "pod") != "mimir-api-key" || db.SecretName("mimir", "pod") != "mimir-pod-db" {
+		t.Error("Secret names were not generated as expected")
+	}
+
+	if _, err := ParsePodSecrets(map[string]string{Remote + ".db": ""}); err == nil {
+		t.Error("Expected an error for an empty remote")
+	}
+	if _, err := ParsePodSecrets(map[string]string{Remote + ".DB_1": "mock"}); err == nil {
+		t.Error("Expected an error for an invalid id")
+	}
+}
+
+func TestPlanPodSecretCollection(t *testing.T) {
+	now := time.Now()
+	mockSecret := func(name string, age time.Duration, owners ...string) core_v1.Secret {
+		secret := core_v1.Secret{}
+		secret.Name = name
+		secret.CreationTimestamp = meta_v1.NewTime(now.Add(-age))
+		for _, owner := range owners {
+			secret.OwnerReferences = append(secret.OwnerReferences, meta_v1.OwnerReference{Kind: "Pod", UID: types.UID(owner)})
+		}
+		return secret
+	}
+
+	annotated := core_v1.Pod{}
+	annotated.UID = "annotated"
+	annotated.Annotations = map[string]string{Secrets: "mimir-a,mimir-shared"}
+	mounted := core_v1.Pod{}
+	mounted.UID = "mounted"
+	mounted.Spec.Volumes = []core_v1.Volume{core_v1.Volume{
+		VolumeSource: core_v1.VolumeSource{Secret: &core_v1.SecretVolumeSource{SecretName: "mimir-shared"}},
+	}}
+
+	collection := planPodSecretCollection([]core_v1.Secret{
+		mockSecret("mimir-a", time.Hour),
+		mockSecret("mimir-shared", time.Hour, "annotated"),
+		mockSecret("mimir-orphan", time.Hour),
+		mockSecret("mimir-new", time.Minute),
+	}, []core_v1.Pod{annotated, mounted}, now, 10*time.Minute)
+
+	if len(collection.Orphaned) != 1 || collection.Orphaned[0].Name != "mimir-orphan" {
+		t.Error("Only the secret without a pod that is past the grace period should be orphaned")
+	}
+	if len(collection.Adopted) != 2 || len(collection.Adopted["mimir-a"]) != 1 || collection.Adopted["mimir-a"][0].UID != "annotated" {
+		t.Error("Secret listed by a pod should be adopted by it")
+	}
+	if len(collection.Adopted["mimir-shared"]) != 1 || collection.Adopted["mimir-shared"][0].UID != "mounted" {
+		t.Error("Shared secret should only be adopted by the pods it is not yet owned by")
+	}
+}
+
+func TestHookSecrets(t *testing.T) {
+	mockSecret := func(name string, labels, annotations map[string]string) core_v1.Secret {
+		secret := core_v1.Secret{}
+		secret.Name = name
+		secret.Labels = labels
+		secret.Annotations = annotations
+		return secret
+	}
+
+	secrets := hookSecrets([]core_v1.Secret{
+		mockSecret("mimir-labelled", map[string]string{Hook: "hook"}, nil),
+		mockSecret("mimir-legacy", nil, map[string]string{Hook: "hook"}),
+		mockSecret("mimir-other", map[string]string{Hook: "other"}, map[string]string{Hook: "hook"}),
+		mockSecret("app-legacy", nil, map[string]string{Hook: "hook"}),
+		mockSecret("mimir-unmanaged", nil, nil),
+	}, "hook", "mimir")
+
+	if len(secrets) != 2 || secrets[0].Name != "mimir-labelled" || secrets[1].Name != "mimir-legacy" {
+		t.Errorf("Expected the labelled and legacy secrets of the hook, got %v", secrets)
+	}
+}
@@ -1,5 +1,11 @@
 package clients
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 // SecretsManagerClient is the common interface used for
 // interacting with any kind of backend Secrets manager.
 // All integrations with a secrets manager should
@@ -9,10 +15,63 @@ type SecretsManagerClient interface {
 	GetSecret(path string) (*Secret, error)
 }
 
+// closeClient stops any background work held by a client that is no longer used, if the
+// client has any
+func closeClient(client SecretsManagerClient) {
+	if closer, ok := client.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// FailedNamespacesError is returned by GetSecrets, along with the secrets that did load, when the
+// secrets of some namespaces could not be loaded. Those namespaces should be left alone, rather than
+// have their managed secrets deleted as if they were no longer in the backend
+type FailedNamespacesError struct {
+	Namespaces map[string]error
+}
+
+// Error lists the namespaces that failed, and why
+func (err *FailedNamespacesError) Error() string {
+	namespaces := make([]string, 0)
+	for namespace := range err.Namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	failures := make([]string, 0)
+	for _, namespace := range namespaces {
+		failures = append(failures, fmt.Sprintf("%s: %s", namespace, err.Namespaces[namespace].Error()))
+	}
+	return fmt.Sprintf("Failed to load the secrets of namespaces %s", strings.Join(failures, ", "))
+}
+
+// FailedNamespaces provides the namespaces that failed to load when the error returned by
+// GetSecrets is a FailedNamespacesError, so a sync can carry on without them. Any other error is
+// returned as it is
+func FailedNamespaces(err error) ([]string, error) {
+	if err == nil {
+		return nil, nil
+	}
+	failedErr, ok := err.(*FailedNamespacesError)
+	if !ok {
+		return nil, err
+	}
+	namespaces := make([]string, 0)
+	for namespace := range failedErr.Namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
 // Secret is a common struct designed as an intermediary
 // struct between a backend secrets manager, and k8s
 type Secret struct {
 	Name      string
 	Namespace string
 	Data      map[string]string
+	// Type is the k8s secret type, or an alias of it, that the secret should be created as
+	Type string
+	// remotePath is the location of the secret below its namespace in the backend, when it is
+	// not the same as the name of the secret
+	remotePath string
 }
@@ -0,0 +1,150 @@
+package clients
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// secretTypeAliases are the short names that can be used to select a k8s secret type
+var secretTypeAliases = map[string]core_v1.SecretType{
+	"opaque":           core_v1.SecretTypeOpaque,
+	"tls":              core_v1.SecretTypeTLS,
+	"dockerconfigjson": core_v1.SecretTypeDockerConfigJson,
+	"basic-auth":       core_v1.SecretTypeBasicAuth,
+	"ssh-auth":         core_v1.SecretTypeSSHAuth,
+}
+
+// Keys that a dockerconfigjson secret can be built from, when the secret does not already
+// hold a .dockerconfigjson key
+const (
+	dockerRegistryKey = "registry"
+	dockerUsernameKey = "username"
+	dockerPasswordKey = "password"
+	dockerEmailKey    = "email"
+)
+
+// dockerConfigJSON is the format of the .dockerconfigjson key of a registry pull secret
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+// dockerConfigEntry holds the credentials for a single registry in a dockerconfigjson
+type dockerConfigEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email,omitempty"`
+	Auth     string `json:"auth"`
+}
+
+// ParseSecretType converts a secret type, either in full or by one of its aliases, into the k8s
+// secret type. An empty type is Opaque
+func ParseSecretType(value string) (core_v1.SecretType, error) {
+	if value == "" {
+		return core_v1.SecretTypeOpaque, nil
+	}
+	if secretType, ok := secretTypeAliases[strings.ToLower(value)]; ok {
+		return secretType, nil
+	}
+	for _, secretType := range secretTypeAliases {
+		if string(secretType) == value {
+			return secretType, nil
+		}
+	}
+	return "", fmt.Errorf("Unsupported secret type %s", value)
+}
+
+// PrepareK8SSecretData converts the data of a remote secret into the type and data of a k8s
+// secret. The type is taken from secretType, or if that is empty, from the mimir-type key of the
+// data, which is never copied into k8s. The data is checked to hold the keys required by the type
+func PrepareK8SSecretData(secretType string, data map[string]string) (core_v1.SecretType, map[string][]byte, error) {
+	k8sData := make(map[string][]byte)
+	for k, v := range data {
+		if k == Type {
+			if secretType == "" {
+				secretType = v
+			}
+			continue
+		}
+		k8sData[k] = []byte(v)
+	}
+
+	k8sType, err := ParseSecretType(secretType)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := validateSecretData(k8sType, k8sData); err != nil {
+		return "", nil, err
+	}
+	return k8sType, k8sData, nil
+}
+
+// validateSecretData checks the data holds the keys required for the secret type, building a
+// dockerconfigjson from the registry, username and password keys if it is not already present
+func validateSecretData(secretType core_v1.SecretType, data map[string][]byte) error {
+	switch secretType {
+	case core_v1.SecretTypeTLS:
+		return requireSecretKeys(secretType, data, core_v1.TLSCertKey, core_v1.TLSPrivateKeyKey)
+	case core_v1.SecretTypeSSHAuth:
+		return requireSecretKeys(secretType, data, core_v1.SSHAuthPrivateKey)
+	case core_v1.SecretTypeBasicAuth:
+		_, hasUsername := data[core_v1.BasicAuthUsernameKey]
+		_, hasPassword := data[core_v1.BasicAuthPasswordKey]
+		if !hasUsername && !hasPassword {
+			return fmt.Errorf("Secrets of type %s need at least one of the keys %s or %s", secretType, core_v1.BasicAuthUsernameKey, core_v1.BasicAuthPasswordKey)
+		}
+	case core_v1.SecretTypeDockerConfigJson:
+		if _, ok := data[core_v1.DockerConfigJsonKey]; !ok {
+			if err := requireSecretKeys(secretType, data, dockerRegistryKey, dockerUsernameKey, dockerPasswordKey); err != nil {
+				return fmt.Errorf("%s, or the keys to build it from: %s", err.Error(), core_v1.DockerConfigJsonKey)
+			}
+			return buildDockerConfigJSON(data)
+		}
+		var config dockerConfigJSON
+		if err := json.Unmarshal(data[core_v1.DockerConfigJsonKey], &config); err != nil {
+			return fmt.Errorf("Key %s is not valid JSON: %s", core_v1.DockerConfigJsonKey, err.Error())
+		}
+	}
+	return nil
+}
+
+// requireSecretKeys checks that all of the keys are present in the data
+func requireSecretKeys(secretType core_v1.SecretType, data map[string][]byte, keys ...string) error {
+	missing := make([]string, 0)
+	for _, key := range keys {
+		if _, ok := data[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Secrets of type %s need the keys %s", secretType, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// buildDockerConfigJSON replaces the registry, username, password and email keys of the data
+// with a .dockerconfigjson holding the credentials for the registry
+func buildDockerConfigJSON(data map[string][]byte) error {
+	username := string(data[dockerUsernameKey])
+	password := string(data[dockerPasswordKey])
+	config := dockerConfigJSON{Auths: map[string]dockerConfigEntry{
+		string(data[dockerRegistryKey]): dockerConfigEntry{
+			Username: username,
+			Password: password,
+			Email:    string(data[dockerEmailKey]),
+			Auth:     base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password))),
+		},
+	}}
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{dockerRegistryKey, dockerUsernameKey, dockerPasswordKey, dockerEmailKey} {
+		delete(data, key)
+	}
+	data[core_v1.DockerConfigJsonKey] = configJSON
+	return nil
+}
@@ -0,0 +1,71 @@
+package clients
+
+import (
+	"encoding/json"
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+func TestParseSecretType(t *testing.T) {
+	for value, expected := range map[string]core_v1.SecretType{
+		"":                         core_v1.SecretTypeOpaque,
+		"TLS":                      core_v1.SecretTypeTLS,
+		"dockerconfigjson":         core_v1.SecretTypeDockerConfigJson,
+		"kubernetes.io/basic-auth": core_v1.SecretTypeBasicAuth,
+		"ssh-auth":                 core_v1.SecretTypeSSHAuth,
+	} {
+		secretType, err := ParseSecretType(value)
+		if err != nil || secretType != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, value, secretType)
+		}
+	}
+	if _, err := ParseSecretType("mock"); err == nil {
+		t.Error("Expected an error for an unknown secret type")
+	}
+}
+
+func TestPrepareK8SSecretData(t *testing.T) {
+	secretType, data, err := PrepareK8SSecretData("", map[string]string{Type: "tls", "tls.crt": "mock", "tls.key": "mock"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secretType != core_v1.SecretTypeTLS {
+		t.Error("Expected the type to be taken from the data")
+	}
+	if _, ok := data[Type]; ok {
+		t.Error("The type key should not be copied into the secret data")
+	}
+
+	if _, _, err := PrepareK8SSecretData("tls", map[string]string{"tls.crt": "mock"}); err == nil {
+		t.Error("Expected an error for a tls secret missing its key")
+	}
+	if _, _, err := PrepareK8SSecretData("basic-auth", map[string]string{"mock": "mock"}); err == nil {
+		t.Error("Expected an error for a basic-auth secret with no username or password")
+	}
+	if _, _, err := PrepareK8SSecretData("dockerconfigjson", map[string]string{".dockerconfigjson": "mock"}); err == nil {
+		t.Error("Expected an error for an invalid dockerconfigjson")
+	}
+}
+
+func TestBuildDockerConfigJSON(t *testing.T) {
+	_, data, err := PrepareK8SSecretData("dockerconfigjson", map[string]string{
+		"registry": "registry.mock",
+		"username": "mock",
+		"password": "mock",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 {
+		t.Error("Expected only the .dockerconfigjson key to remain")
+	}
+	var config dockerConfigJSON
+	if err := json.Unmarshal(data[core_v1.DockerConfigJsonKey], &config); err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := config.Auths["registry.mock"]
+	if !ok || entry.Username != "mock" || entry.Auth != "bW9jazptb2Nr" {
+		t.Error("Registry credentials were not built as expected")
+	}
+}
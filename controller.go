@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/marmotherder/mimir/clients"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// runController keeps mimir running as a controller for MimirSecret resources. On every resync
+// the resources for this backend that are due a refresh are reconciled into k8s secrets, and the
+// result is written back to the status of each resource.
+func runController(opts Options, cOpts ControllerOptions, smc clients.SecretsManagerClient, mgr clients.SecretsManager) {
+	if cOpts.Resync <= 0 {
+		log.Fatalln("The controller resync must be greater than zero")
+	}
+	if cOpts.RefreshInterval <= 0 {
+		log.Fatalln("The default refresh interval must be greater than zero")
+	}
+
+	kc, err := clients.NewK8SClient(opts.IsPod, opts.KubeconfigPath)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	dc, err := clients.NewK8SDynamicClient(opts.IsPod, opts.KubeconfigPath)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	if cOpts.MetricsPort > 0 {
+		srv := serveMetrics(cOpts.MetricsPort)
+		defer srv.Shutdown(context.Background())
+	}
+
+	log.Printf("Running mimir as a controller for %s resources, resyncing every %s\n", clients.MimirSecretKind, cOpts.Resync)
+	for {
+		reconcileMimirSecrets(kc, dc, smc, mgr, cOpts.RefreshInterval)
+
+		select {
+		case sig := <-stop:
+			log.Printf("Received %s, stopping the controller\n", sig)
+			return
+		case <-time.After(cOpts.Resync):
+		}
+	}
+}
+
+// reconcileMimirSecrets reconciles every MimirSecret for the backend that is due a refresh
+func reconcileMimirSecrets(kc *kubernetes.Clientset, dc dynamic.Interface, smc clients.SecretsManagerClient, mgr clients.SecretsManager, refreshInterval time.Duration) {
+	mimirSecrets, err := clients.ListMimirSecrets(dc)
+	if err != nil {
+		log.Printf("Failed to list %s resources: %s\n", clients.MimirSecretKind, err.Error())
+		return
+	}
+
+	for _, ms := range mimirSecrets {
+		if ms.Spec.Backend != "" && ms.Spec.Backend != mgr {
+			continue
+		}
+
+		now := time.Now()
+		due, err := ms.IsDue(now, refreshInterval)
+		if err == nil && !due {
+			continue
+		}
+		if err == nil {
+			var action clients.SecretAction
+			action, err = clients.ReconcileMimirSecret(kc, smc, mgr, ms)
+			if err == nil && action != clients.UnchangedSecret {
+				log.Printf("Reconciled %s %s in namespace %s: %s secret %s\n", clients.MimirSecretKind, ms.Name, ms.Namespace, action, ms.TargetName())
+			}
+		}
+
+		if err != nil {
+			log.Printf("Failed to reconcile %s %s in namespace %s: %s\n", clients.MimirSecretKind, ms.Name, ms.Namespace, err.Error())
+			ms.SetCondition(clients.ReadyCondition, core_v1.ConditionFalse, "SyncFailed", err.Error(), now)
+		} else {
+			ms.SetCondition(clients.ReadyCondition, core_v1.ConditionTrue, "Synced", "", now)
+			syncTime := meta_v1.NewTime(now)
+			ms.Status.LastSyncTime = &syncTime
+		}
+		ms.Status.ObservedGeneration = ms.Generation
+
+		if err := clients.UpdateMimirSecretStatus(dc, ms); err != nil {
+			log.Printf("Failed to update the status of %s %s in namespace %s: %s\n", clients.MimirSecretKind, ms.Name, ms.Namespace, err.Error())
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/marmotherder/mimir/clients"
+)
+
+// runDaemon keeps mimir running, syncing secrets from the backend into kubernetes on every
+// interval. A stop signal received mid cycle is only acted upon once that cycle has finished,
+// so secrets are never left half managed.
+func runDaemon(opts Options, dOpts DaemonOptions, smc clients.SecretsManagerClient, mgr clients.SecretsManager) {
+	if dOpts.Interval <= 0 {
+		log.Fatalln("The resync interval must be greater than zero")
+	}
+	if dOpts.Jitter < 0 {
+		log.Fatalln("The resync jitter can not be negative")
+	}
+
+	kc, err := clients.NewK8SClient(opts.IsPod, opts.KubeconfigPath)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	rand.Seed(time.Now().UnixNano())
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	if dOpts.MetricsPort > 0 {
+		srv := serveMetrics(dOpts.MetricsPort)
+		defer srv.Shutdown(context.Background())
+	}
+
+	log.Printf("Running mimir as a daemon, resyncing every %s with up to %s jitter\n", dOpts.Interval, dOpts.Jitter)
+	for cycle := 1; ; cycle++ {
+		start := time.Now()
+		summary, err := syncSecrets(opts, kc, smc, mgr)
+		if err != nil {
+			log.Printf("Sync cycle %d failed after %s: %s\n", cycle, time.Since(start), err.Error())
+		} else {
+			log.Printf("Sync cycle %d completed in %s: %s\n", cycle, time.Since(start), summary)
+		}
+
+		wait := nextInterval(dOpts.Interval, dOpts.Jitter)
+		select {
+		case sig := <-stop:
+			log.Printf("Received %s, stopping the daemon\n", sig)
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// nextInterval provides the time to wait before the next cycle, adding a random amount of
+// jitter up to the configured maximum
+func nextInterval(interval, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int63n(int64(jitter)))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/marmotherder/mimir/clients"
+)
+
+// runDryRun works out the changes a sync would make for the given backend, and prints them out
+// without touching any secrets in the cluster
+func runDryRun(opts Options, drOpts DryRunOptions, smc clients.SecretsManagerClient, mgr clients.SecretsManager) {
+	kc, err := clients.NewK8SClient(opts.IsPod, opts.KubeconfigPath)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	namespaces, err := clients.GetNamespaces(kc)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	secrets, err := smc.GetSecrets(namespaces...)
+	failed, err := clients.FailedNamespaces(err)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if len(failed) > 0 {
+		log.Printf("Failed to load the secrets of namespaces %s, their secrets will not be deleted\n", strings.Join(failed, ", "))
+	}
+	plan, err := clients.PlanSecrets(kc, mgr, secrets...)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	plan.SkipDeletes(failed...)
+	if err := printPlan(os.Stdout, plan, drOpts.Format); err != nil {
+		log.Fatalln(err.Error())
+	}
+}
+
+// printPlan writes out a plan in the requested format. Secret values are never part of a plan,
+// only the names of the keys that would change
+func printPlan(w io.Writer, plan *clients.SecretsPlan, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	}
+
+	counts := make(map[clients.SecretAction]int)
+	for _, change := range plan.Changes {
+		counts[change.Action]++
+		if change.Action == clients.UnchangedSecret {
+			continue
+		}
+		symbol := "~"
+		switch change.Action {
+		case clients.CreateSecret:
+			symbol = "+"
+		case clients.DeleteSecret:
+			symbol = "-"
+		}
+		fmt.Fprintf(w, "%s %s secret %s/%s\n", symbol, change.Action, change.Namespace, change.Name)
+		printPlanKeys(w, "added", change.AddedKeys)
+		printPlanKeys(w, "changed", change.ChangedKeys)
+		printPlanKeys(w, "removed", change.RemovedKeys)
+	}
+	_, err := fmt.Fprintf(w, "Plan: %d to create, %d to update, %d to delete, %d unchanged\n",
+		counts[clients.CreateSecret], counts[clients.UpdateSecret], counts[clients.DeleteSecret], counts[clients.UnchangedSecret])
+	return err
+}
+
+// printPlanKeys writes out a line of key names for a change, if there are any
+func printPlanKeys(w io.Writer, label string, keys []string) {
+	if len(keys) > 0 {
+		fmt.Fprintf(w, "    %s keys: %s\n", label, strings.Join(keys, ", "))
+	}
+}
@@ -15,6 +15,10 @@ else
     envsubst < templates/csr.yaml > csr.yaml
     kubectl apply -f csr.yaml
     kubectl certificate approve "${RELEASE}"
+    for i in $(seq 1 30); do
+        [ -n "$(kubectl get csr "${RELEASE}" -o jsonpath='{.status.certificate}')" ] && break
+        sleep 2
+    done
     kubectl get csr "${RELEASE}" -o jsonpath='{.status.certificate}' | base64 -d > server-cert.pem
     export CA_BUNDLE=$(cat /var/run/secrets/kubernetes.io/serviceaccount/ca.crt | base64 | tr -d '\n')
 fi
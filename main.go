@@ -1,17 +1,22 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/marmotherder/mimir/clients"
 
 	"github.com/gorilla/mux"
 	"github.com/jessevdk/go-flags"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/kubernetes"
 )
 
 var opts Options
@@ -19,6 +24,11 @@ var sOpts ServerOptions
 var release string
 var re bool
 
+// serverClient is the secrets manager client loaded once when running as a webhook server, and
+// serverMgr the backend it loads secrets from
+var serverClient clients.SecretsManagerClient
+var serverMgr clients.SecretsManager
+
 func main() {
 	parseArgs(&opts)
 
@@ -34,9 +44,16 @@ func main() {
 
 		r := mux.NewRouter()
 		r.HandleFunc("/hook", hook).Methods(http.MethodPost)
+		r.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
 		parseArgs(&sOpts)
 		log.Printf("Running server on port: %d\n", sOpts.ServerPort)
 
+		serverClient, serverMgr = loadServerClient()
+
+		if sOpts.GCInterval > 0 {
+			go runPodSecretGC()
+		}
+
 		srv := &http.Server{
 			Addr:    fmt.Sprintf(":%d", sOpts.ServerPort),
 			Handler: r,
@@ -62,7 +79,27 @@ func main() {
 		if err != nil {
 			log.Fatalln(err.Error())
 		}
-		run(opts, smc, mgr)
+		if opts.DaemonMode && opts.Controller {
+			log.Fatalln("Daemon and controller modes can not be used together")
+		}
+		if opts.DaemonMode && opts.DryRun {
+			log.Fatalln("Daemon and dry run modes can not be used together")
+		}
+		if opts.DryRun {
+			var drOpts DryRunOptions
+			parseArgs(&drOpts)
+			runDryRun(opts, drOpts, smc, mgr)
+		} else if opts.DaemonMode {
+			var dOpts DaemonOptions
+			parseArgs(&dOpts)
+			runDaemon(opts, dOpts, smc, mgr)
+		} else if opts.Controller {
+			var cOpts ControllerOptions
+			parseArgs(&cOpts)
+			runController(opts, cOpts, smc, mgr)
+		} else {
+			run(opts, smc, mgr)
+		}
 	}
 }
 
@@ -76,32 +113,112 @@ func parseArgs(opts interface{}) {
 	}
 }
 
-// loadHashiCorpVaultClient loads a valid client for loading secrets from Hashicorp Vault
-func loadHashiCorpVaultClient(opts Options, hvOpts HashiCorpVaultOptions, auth clients.HashicorpVaultAuth) (smc clients.SecretsManagerClient, mgr clients.SecretsManager) {
-	client, err := clients.NewHashicorpVaultClient(hvOpts.Path, hvOpts.URL, hvOpts.Mount, hvOpts.SkipTLSVerify, auth)
+// loadServerClient loads the secrets manager client for the webhook server once, rather than
+// for every request. It is reloaded in the background to keep its credentials fresh, and can
+// cache the secrets it loads
+func loadServerClient() (clients.SecretsManagerClient, clients.SecretsManager) {
+	smc, mgr, err := loadClient()
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
-	return client, clients.HashicorpVault
+	if sOpts.ClientRefresh > 0 {
+		smc = clients.NewRefreshingClient(smc, func() (clients.SecretsManagerClient, error) {
+			smc, _, err := loadClient()
+			return smc, err
+		}, sOpts.ClientRefresh)
+	}
+	if sOpts.CacheTTL > 0 {
+		smc = clients.NewCachingClient(smc, sOpts.CacheTTL)
+	}
+	return smc, mgr
+}
+
+// loadHashiCorpVaultClient loads a valid client for loading secrets from Hashicorp Vault
+func loadHashiCorpVaultClient(opts Options, hvOpts HashiCorpVaultOptions, auth clients.HashicorpVaultAuth) (smc clients.SecretsManagerClient, mgr clients.SecretsManager, err error) {
+	options := make([]clients.HashicorpVaultOption, 0)
+	if hvOpts.Recursive {
+		options = append(options, clients.WithVaultRecursion(hvOpts.Separator, hvOpts.MaxDepth))
+	}
+	if hvOpts.Flatten {
+		options = append(options, clients.WithVaultFlatten())
+	}
+	if hvOpts.Namespace != "" {
+		options = append(options, clients.WithVaultNamespace(hvOpts.Namespace))
+	}
+	if hvOpts.MapNamespaces {
+		options = append(options, clients.WithVaultNamespaceMapping())
+	}
+	if len(hvOpts.DynamicSecrets) > 0 {
+		// Leases are only held in memory, so a single run would issue new credentials every time
+		if !opts.DaemonMode {
+			return nil, "", errors.New("Dynamic secrets can only be synced when running as a daemon")
+		}
+		sources := make([]*clients.VaultDynamicSource, 0)
+		for _, value := range hvOpts.DynamicSecrets {
+			source, err := clients.ParseVaultDynamicSource(value)
+			if err != nil {
+				return nil, "", err
+			}
+			sources = append(sources, source)
+		}
+		options = append(options, clients.WithVaultDynamicSources(sources...))
+	}
+	// Only the long running modes need the token kept valid
+	if opts.ServerMode || opts.DaemonMode || opts.Controller {
+		options = append(options, clients.WithVaultTokenRenewal())
+	}
+	client, err := clients.NewHashicorpVaultClient(hvOpts.Path, hvOpts.URL, hvOpts.Mount, hvOpts.SkipTLSVerify, auth, options...)
+	clients.RecordBackendLogin(clients.HashicorpVault, err)
+	if err != nil {
+		return nil, "", err
+	}
+	return clients.NewInstrumentedClient(client, clients.HashicorpVault), clients.HashicorpVault, nil
 }
 
 // loadAWSClient loads a valid client for loading secrets from AWS Secrets Manager
-func loadAWSClient(opts Options, awsOpts AWSOptions, auth clients.AWSSecretsAuth) (smc clients.SecretsManagerClient, mgr clients.SecretsManager) {
+func loadAWSClient(opts Options, awsOpts AWSOptions, auth clients.AWSSecretsAuth) (smc clients.SecretsManagerClient, mgr clients.SecretsManager, err error) {
 	auth.SetRegion(awsOpts.Region)
-	client, err := clients.NewAWSSecretsClient(auth)
+	options := []clients.AWSSecretsOption{clients.WithAWSConcurrency(awsOpts.Concurrency), clients.WithAWSRawKey(awsOpts.RawKey)}
+	if awsOpts.PreviousSuffix != "" {
+		options = append(options, clients.WithAWSPreviousVersions(awsOpts.PreviousSuffix))
+	}
+	client, err := clients.NewAWSSecretsClient(auth, options...)
+	clients.RecordBackendLogin(clients.AWS, err)
 	if err != nil {
-		log.Fatalln(err.Error())
+		return nil, "", err
 	}
-	return client, clients.AWS
+	return clients.NewInstrumentedClient(client, clients.AWS), clients.AWS, nil
+}
+
+// loadAWSParameterStoreClient loads a valid client for loading secrets from AWS Systems Manager Parameter Store
+func loadAWSParameterStoreClient(opts Options, awsOpts AWSOptions, ssmOpts AWSParameterStoreOptions, auth clients.AWSSecretsAuth) (smc clients.SecretsManagerClient, mgr clients.SecretsManager, err error) {
+	auth.SetRegion(awsOpts.Region)
+	client, err := clients.NewAWSParameterStoreClient(auth, ssmOpts.Root)
+	clients.RecordBackendLogin(clients.AWSParameterStore, err)
+	if err != nil {
+		return nil, "", err
+	}
+	return clients.NewInstrumentedClient(client, clients.AWSParameterStore), clients.AWSParameterStore, nil
 }
 
 // loadAzureKeyVaultClient loads a valid client for loading secrets from Azure Key Vaults
-func loadAzureKeyVaultClient(opts Options, azOpts AzureKeyVaultOptions, auth clients.AzureKeyVaultAuth) (smc clients.SecretsManagerClient, mgr clients.SecretsManager) {
+func loadAzureKeyVaultClient(opts Options, azOpts AzureKeyVaultOptions, auth clients.AzureKeyVaultAuth) (smc clients.SecretsManagerClient, mgr clients.SecretsManager, err error) {
 	client, err := clients.NewAzureKeyVaultClient(auth, azOpts.SubscriptionID)
+	clients.RecordBackendLogin(clients.Azure, err)
 	if err != nil {
-		log.Fatalln(err.Error())
+		return nil, "", err
 	}
-	return client, clients.AWS
+	return clients.NewInstrumentedClient(client, clients.Azure), clients.Azure, nil
+}
+
+// loadGCPClient loads a valid client for loading secrets from GCP Secret Manager
+func loadGCPClient(opts Options, gcpOpts GCPOptions, auth clients.GCPSecretsAuth) (smc clients.SecretsManagerClient, mgr clients.SecretsManager, err error) {
+	client, err := clients.NewGCPSecretsClient(auth, gcpOpts.Project)
+	clients.RecordBackendLogin(clients.GCP, err)
+	if err != nil {
+		return nil, "", err
+	}
+	return clients.NewInstrumentedClient(client, clients.GCP), clients.GCP, nil
 }
 
 // run performs a run of mimir secret syncing for the given backend
@@ -110,16 +227,44 @@ func run(opts Options, smc clients.SecretsManagerClient, mgr clients.SecretsMana
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
-	namespaces, err := clients.GetNamespaces(kc)
+	summary, err := syncSecrets(opts, kc, smc, mgr)
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
+	log.Printf("Sync complete: %s\n", summary)
+}
+
+// syncSecrets loads the secrets for every namespace in the cluster from the backend, and then
+// manages them in kubernetes, restarting the workloads that consume updated secrets if asked to
+func syncSecrets(opts Options, kc *kubernetes.Clientset, smc clients.SecretsManagerClient, mgr clients.SecretsManager) (summary *clients.SyncSummary, err error) {
+	start := time.Now()
+	defer func() { recordSync(mgr, start, err) }()
+
+	namespaces, err := clients.GetNamespaces(kc)
+	if err != nil {
+		return nil, err
+	}
 	secrets, err := smc.GetSecrets(namespaces...)
+	failed, err := clients.FailedNamespaces(err)
 	if err != nil {
-		log.Fatalln(err.Error())
+		return nil, err
 	}
-	err = clients.ManageSecrets(kc, mgr, secrets...)
+	if len(failed) > 0 {
+		log.Printf("Failed to load the secrets of namespaces %s, their secrets will not be deleted\n", strings.Join(failed, ", "))
+	}
+	plan, err := clients.PlanSecrets(kc, mgr, secrets...)
 	if err != nil {
-		log.Fatalln(err.Error())
+		return nil, err
+	}
+	plan.SkipDeletes(failed...)
+	summary, err = clients.ApplyPlan(kc, plan)
+	if err != nil {
+		return summary, err
+	}
+	if opts.Restart {
+		if restarted := clients.RestartWorkloads(kc, plan); restarted > 0 {
+			log.Printf("Restarted %d workloads consuming updated secrets\n", restarted)
+		}
 	}
+	return summary, nil
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/marmotherder/mimir/clients"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/api/admission/v1beta1"
+)
+
+var (
+	webhookRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mimir_webhook_requests_total",
+		Help: "Number of admission requests handled by the webhook, by operation and outcome",
+	}, []string{"operation", "outcome"})
+	webhookLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mimir_webhook_request_duration_seconds",
+		Help:    "Time taken to handle admission requests",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+	syncRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mimir_sync_runs_total",
+		Help: "Number of syncs of secrets from the backend into kubernetes, by result",
+	}, []string{"backend", "result"})
+	syncLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mimir_sync_duration_seconds",
+		Help:    "Time taken by a full sync of secrets from the backend into kubernetes",
+		Buckets: []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"backend"})
+	syncLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mimir_sync_last_success_timestamp_seconds",
+		Help: "Unix time of the last sync that completed without error",
+	}, []string{"backend"})
+)
+
+func init() {
+	prometheus.MustRegister(webhookRequests, webhookLatency, syncRuns, syncLatency, syncLastSuccess)
+}
+
+// recordSync records the result and duration of a single sync
+func recordSync(mgr clients.SecretsManager, start time.Time, err error) {
+	syncLatency.WithLabelValues(string(mgr)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		syncRuns.WithLabelValues(string(mgr), "error").Inc()
+		return
+	}
+	syncRuns.WithLabelValues(string(mgr), "success").Inc()
+	syncLastSuccess.WithLabelValues(string(mgr)).SetToCurrentTime()
+}
+
+// recordWebhook records the outcome and duration of a single admission request. Requests are
+// either mutated, allowed without changes, or an error if the hook could not allow them
+func recordWebhook(ar *v1beta1.AdmissionReview, as v1beta1.AdmissionResponse, start time.Time) {
+	operation := "unknown"
+	if ar != nil && ar.Request != nil {
+		operation = string(ar.Request.Operation)
+	}
+	outcome := "allowed"
+	if !as.Allowed {
+		outcome = "error"
+	} else if len(as.Patch) > 0 {
+		outcome = "mutated"
+	}
+	webhookLatency.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	webhookRequests.WithLabelValues(operation, outcome).Inc()
+}
+
+// serveMetrics starts a plain http server exposing the prometheus metrics on the given port
+func serveMetrics(port int) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: mux,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Println(err.Error())
+		}
+	}()
+	log.Printf("Serving metrics on port: %d\n", port)
+	return srv
+}
@@ -1,28 +1,65 @@
 package main
 
+import "time"
+
 // Options is the common mimir options available
 type Options struct {
 	ServerMode     bool    `short:"o" long:"server" description:"Should the application run as a webserver?"`
-	Backend        string  `short:"b" long:"backend" choice:"hashicorpvault" choice:"aws" choice:"azure" description:"The secrets manager backend to be used" required:"true"`
+	Backend        string  `short:"b" long:"backend" choice:"hashicorpvault" choice:"aws" choice:"ssm" choice:"azure" choice:"gcp" description:"The secrets manager backend to be used" required:"true"`
 	IsPod          bool    `short:"i" long:"ispod" description:"Is the application being run within a pod?"`
 	KubeconfigPath *string `short:"k" long:"kcpath" description:"An absolute path to a valid kube config file"`
+	DaemonMode     bool    `long:"daemon" description:"Should the application keep running and resync secrets on an interval?"`
+	DryRun         bool    `long:"dry-run" description:"Print the changes a sync would make, without changing anything in the cluster"`
+	Restart        bool    `long:"restart-workloads" description:"Should workloads consuming an updated secret be restarted?"`
+	Controller     bool    `long:"controller" description:"Should the application run as a controller for MimirSecret resources?"`
+}
+
+// DryRunOptions is used for configuring how a dry run plan is printed
+type DryRunOptions struct {
+	Format string `long:"format" choice:"text" choice:"json" description:"The format to print the dry run plan in" default:"text"`
+}
+
+// DaemonOptions is used for the daemon mode specific configuration
+type DaemonOptions struct {
+	Interval    time.Duration `long:"interval" description:"How long to wait between each resync of secrets" default:"5m"`
+	Jitter      time.Duration `long:"jitter" description:"Maximum random delay added to each interval, to spread load on the backend" default:"30s"`
+	MetricsPort int           `long:"metrics-port" description:"Port to serve prometheus metrics on, set to 0 to disable" default:"9090"`
+}
+
+// ControllerOptions is used for the MimirSecret controller specific configuration
+type ControllerOptions struct {
+	Resync          time.Duration `long:"resync" description:"How often to check MimirSecret resources for changes or a due refresh" default:"30s"`
+	RefreshInterval time.Duration `long:"refresh-interval" description:"How often to reload a MimirSecret from the backend when it does not set its own refreshInterval" default:"5m"`
+	MetricsPort     int           `long:"metrics-port" description:"Port to serve prometheus metrics on, set to 0 to disable" default:"9090"`
 }
 
 // ServerOptions is used for the webhook server specific configuration
 type ServerOptions struct {
-	ServerPort  int    `short:"d" long:"port" description:"Port to run the server against" default:"443"`
-	TLSCertPath string `short:"c" long:"cert" description:"Path to the TLS certificate" required:"true"`
-	TLSKeyPath  string `short:"l" long:"key" description:"Path to the TLS key" required:"true"`
-	Hook        string `short:"h" long:"hook" description:"The identifier for this webhook" required:"true"`
+	ServerPort    int           `short:"d" long:"port" description:"Port to run the server against" default:"443"`
+	TLSCertPath   string        `short:"c" long:"cert" description:"Path to the TLS certificate" required:"true"`
+	TLSKeyPath    string        `short:"l" long:"key" description:"Path to the TLS key" required:"true"`
+	Hook          string        `short:"h" long:"hook" description:"The identifier for this webhook" required:"true"`
+	GCInterval    time.Duration `long:"gc-interval" description:"How often to remove generated secrets whose pod no longer exists, set to 0 to disable" default:"5m"`
+	GCGrace       time.Duration `long:"gc-grace-period" description:"How old a generated secret must be before it can be removed for having no pod" default:"10m"`
+	ClientRefresh time.Duration `long:"client-refresh" description:"How often to reload and reauthenticate the secrets manager client, set to 0 to disable" default:"15m"`
+	CacheTTL      time.Duration `long:"cache-ttl" description:"How long to cache each secret loaded from the secrets manager, set to 0 to disable" default:"0s"`
 }
 
 // HashiCorpVaultOptions is the base configuration options for Hashicorp Valut
 type HashiCorpVaultOptions struct {
-	Authentication string `short:"a" long:"auth" choice:"k8s" choice:"approle" choice:"token" description:"Authentication method to use with Hashicorp Vault" required:"true"`
-	URL            string `short:"u" long:"url" description:"The base URL to the Hashicorp Vault instance" required:"true"`
-	Mount          string `short:"m" long:"mount" description:"Which mount to attach to in the vault" required:"true"`
-	Path           string `short:"p" long:"path" description:"Optional to provide a root path within the mount on where to look for secrets"`
-	SkipTLSVerify  bool   `short:"f" long:"skip" description:"Optional flag to specify if https calls to vault should verify the TLS certificate chain"`
+	Authentication string   `short:"a" long:"auth" choice:"k8s" choice:"approle" choice:"token" choice:"jwt" choice:"cert" choice:"userpass" description:"Authentication method to use with Hashicorp Vault" required:"true"`
+	AuthMount      string   `long:"auth-mount" description:"The path the authentication method is mounted at, if it is not the default for the method, eg. k8s-prod"`
+	URL            string   `short:"u" long:"url" description:"The base URL to the Hashicorp Vault instance" required:"true"`
+	Mount          string   `short:"m" long:"mount" description:"Which mount to attach to in the vault" required:"true"`
+	Path           string   `short:"p" long:"path" description:"Optional to provide a root path within the mount on where to look for secrets"`
+	SkipTLSVerify  bool     `short:"f" long:"skip" description:"Optional flag to specify if https calls to vault should verify the TLS certificate chain"`
+	Recursive      bool     `long:"recursive" description:"Should secrets in directories nested below each namespace directory be loaded?"`
+	Separator      string   `long:"separator" description:"The separator used to join a nested path into the k8s secret name" default:"-"`
+	MaxDepth       int      `long:"max-depth" description:"How many directories deep to look for nested secrets" default:"5"`
+	Flatten        bool     `long:"flatten" description:"Should objects in a secret be flattened into dotted keys, rather than encoded as JSON?"`
+	Namespace      string   `long:"vault-namespace" description:"The Hashicorp Vault Enterprise namespace to log in and read secrets in"`
+	MapNamespaces  bool     `long:"map-namespaces" description:"Should the secrets of each k8s namespace be read from the vault namespace of the same name, rather than a directory in the mount?"`
+	DynamicSecrets []string `long:"dynamic-secret" description:"A secret to sync from a database, aws or pki engine, as namespace/name=path with any params as a query string, eg. default/web-tls=pki/issue/web?common_name=web.default.svc. Can be repeated"`
 }
 
 // HashicorpVaultK8SOptions allows providing the Hashicorp Vault role to bind to via the CLI
@@ -36,6 +73,25 @@ type HashicorpVaultAppRoleOptions struct {
 	SecretID string `short:"s" long:"secretid" description:"The Hashicorp Vault secret ID" required:"true"`
 }
 
+// HashicorpVaultJWTOptions allows providing the Hashicorp Vault role and the jwt to log in with via the CLI
+type HashicorpVaultJWTOptions struct {
+	Role      string `short:"r" long:"role" description:"The Hashicorp Vault role to bind the jwt against" required:"true"`
+	TokenPath string `long:"jwt-path" description:"Path to the jwt, such as a projected service account token" default:"/var/run/secrets/kubernetes.io/serviceaccount/token"`
+}
+
+// HashicorpVaultCertOptions allows providing the TLS client certificate to log in with via the CLI
+type HashicorpVaultCertOptions struct {
+	CertPath string `long:"client-cert" description:"Path to the PEM encoded TLS client certificate" required:"true"`
+	KeyPath  string `long:"client-key" description:"Path to the PEM encoded TLS client key" required:"true"`
+	Name     string `long:"cert-role" description:"The Hashicorp Vault certificate role to log in against, otherwise any matching role is used"`
+}
+
+// HashicorpVaultUserpassOptions allows providing a username and password via the CLI
+type HashicorpVaultUserpassOptions struct {
+	Username string `long:"username" description:"The Hashicorp Vault username" required:"true"`
+	Password string `long:"password" description:"The Hashicorp Vault password" required:"true"`
+}
+
 // HashicorpVaultTokenOptions allows providing an authentication token to Hashicorp Vault via the CLI
 type HashicorpVaultTokenOptions struct {
 	Token string `short:"t" long:"secretid" description:"The Hashicorp Vault token" required:"true"`
@@ -43,8 +99,32 @@ type HashicorpVaultTokenOptions struct {
 
 // AWSOptions is the base configuration options for AWS Secrets Manager
 type AWSOptions struct {
-	Authentication string `short:"a" long:"auth" choice:"iam" choice:"static" choice:"env" choice:"shared" description:"Authentication method to use with AWS" required:"true"`
+	Authentication string `short:"a" long:"auth" choice:"iam" choice:"static" choice:"env" choice:"shared" choice:"webidentity" choice:"assumerole" description:"Authentication method to use with AWS" required:"true"`
 	Region         string `short:"r" long:"region" description:"The AWS region to connect to" required:"true"`
+	Concurrency    int    `long:"concurrency" description:"How many secret values to fetch from AWS at once" default:"10"`
+	PreviousSuffix string `long:"previous-suffix" description:"Add the keys of the previous version of each secret with this suffix, eg. _PREVIOUS"`
+	RawKey         string `long:"raw-key" description:"The key that secrets which are not a JSON object of strings are loaded under" default:"value"`
+}
+
+// AWSWebIdentityOptions allows providing the role and web identity token file to use via the CLI
+type AWSWebIdentityOptions struct {
+	RoleARN     string `long:"role-arn" description:"The ARN of the role to assume with the web identity, otherwise taken from AWS_ROLE_ARN"`
+	SessionName string `long:"session-name" description:"The name of the role session" default:"mimir"`
+	TokenPath   string `long:"web-identity-token-file" description:"Path to the web identity token, otherwise taken from AWS_WEB_IDENTITY_TOKEN_FILE"`
+}
+
+// AWSAssumeRoleOptions allows providing the role to assume, and optionally the web identity to assume it with, via the CLI
+type AWSAssumeRoleOptions struct {
+	RoleARN            string `long:"role-arn" description:"The ARN of the role to assume, such as a role in another account" required:"true"`
+	SessionName        string `long:"session-name" description:"The name of the role session" default:"mimir"`
+	ExternalID         string `long:"external-id" description:"The external ID required by the trust policy of the role"`
+	TokenPath          string `long:"web-identity-token-file" description:"Path to a web identity token to assume the role with, rather than the IAM credentials of mimir"`
+	WebIdentityRoleARN string `long:"web-identity-role-arn" description:"The ARN of the role for the web identity token, otherwise taken from AWS_ROLE_ARN"`
+}
+
+// AWSParameterStoreOptions is the configuration specific to AWS Systems Manager Parameter Store
+type AWSParameterStoreOptions struct {
+	Root string `long:"parameter-root" description:"Optional root path in the parameter hierarchy, below which each namespace is a directory"`
 }
 
 // AWSCredentialsOptions allows providing the AWS ACCESS_KEY_ID and the AWS SECRET_ACCESS_KEY to AWS Secrets Manager via the CLI
@@ -69,3 +149,14 @@ type AzureKeyVaultOptions struct {
 type AzureKeyVaultFileOptions struct {
 	FilePath string `short:"f" long:"path" description:"Path to the Azure credentials file to use for authentication"`
 }
+
+// GCPOptions is the base configuration options for GCP Secret Manager
+type GCPOptions struct {
+	Authentication string `short:"a" long:"auth" choice:"file" choice:"metadata" description:"Authentication method to use with GCP" required:"true"`
+	Project        string `short:"p" long:"project" description:"The GCP project to read secrets from, otherwise it takes from GOOGLE_CLOUD_PROJECT environment variable"`
+}
+
+// GCPFileOptions provides a simple file path if using a service account key file for authentication
+type GCPFileOptions struct {
+	FilePath string `short:"f" long:"path" description:"Path to the GCP service account key file, otherwise it takes from GOOGLE_APPLICATION_CREDENTIALS environment variable"`
+}
@@ -8,16 +8,40 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"strconv"
+	"strings"
+	"time"
 
 	"github.com/marmotherder/mimir/clients"
 
 	"k8s.io/api/admission/v1beta1"
 	core_v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
+// The AdmissionReview versions the hook can answer. admission.k8s.io/v1 is wire compatible with v1beta1,
+// so both are decoded into the v1beta1 types, and the version of the request is echoed in the response
+const (
+	admissionV1      = "admission.k8s.io/v1"
+	admissionV1beta1 = "admission.k8s.io/v1beta1"
+)
+
+// webhookResources are the versions of the webhook configuration api to try when cleaning up, newest first
+var webhookResources = []schema.GroupVersionResource{
+	{Group: "admissionregistration.k8s.io", Version: "v1", Resource: "mutatingwebhookconfigurations"},
+	{Group: "admissionregistration.k8s.io", Version: "v1beta1", Resource: "mutatingwebhookconfigurations"},
+}
+
+// csrResources are the versions of the certificate signing request api to try when cleaning up, newest first
+var csrResources = []schema.GroupVersionResource{
+	{Group: "certificates.k8s.io", Version: "v1", Resource: "certificatesigningrequests"},
+	{Group: "certificates.k8s.io", Version: "v1beta1", Resource: "certificatesigningrequests"},
+}
+
 //patchReq is a struct for a patch request that doesn't exist in types from admission
 type patchReq struct {
 	Op    string      `json:"op"`
@@ -32,6 +56,7 @@ func hook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	start := time.Now()
 	as := v1beta1.AdmissionResponse{}
 	ar, pod, err := readRequest(r.Body)
 
@@ -39,7 +64,7 @@ func hook(w http.ResponseWriter, r *http.Request) {
 		setResultMessage(&as, err.Error())
 	}
 
-	if ar != nil && ar.Request.Operation == v1beta1.Create && err == nil {
+	if err == nil && ar.Request.Operation == v1beta1.Create {
 		if err != nil {
 			setResultMessage(&as, err.Error())
 		} else {
@@ -50,53 +75,72 @@ func hook(w http.ResponseWriter, r *http.Request) {
 				as.Allowed = true
 			}
 		}
-	} else if ar != nil && ar.Request.Operation == v1beta1.Delete && err == nil {
+	} else if err == nil && ar.Request.Operation == v1beta1.Delete {
 		as.Allowed = true
+		secretNames := deletedPodSecrets(ar, pod)
 		kc, err := clients.NewK8SClient(opts.IsPod, opts.KubeconfigPath)
 		if err != nil {
 			setResultMessage(&as, err.Error())
+		} else if isDryRun(ar) {
+			setResultMessage(&as, fmt.Sprintf("Dry run, secrets %s in namespace %s not deleted", strings.Join(secretNames, ", "), ar.Request.Namespace))
 		} else {
-			genName := fmt.Sprintf("%s-%s", release, ar.Request.Name)
-			err := kc.CoreV1().Secrets(ar.Request.Namespace).Delete(genName, &meta_v1.DeleteOptions{})
-			if err != nil {
-				setResultMessage(&as, err.Error())
+			deleted := make([]string, 0)
+			errs := make([]string, 0)
+			for _, secretName := range secretNames {
+				// The names are read from the pod, so only secrets generated by this hook are removed
+				secret, err := kc.CoreV1().Secrets(ar.Request.Namespace).Get(secretName, meta_v1.GetOptions{})
+				if err == nil && secret.Annotations[clients.Hook] != sOpts.Hook {
+					err = fmt.Errorf("Secret %s was not generated by hook %s", secretName, sOpts.Hook)
+				}
+				if err == nil {
+					err = kc.CoreV1().Secrets(ar.Request.Namespace).Delete(secretName, &meta_v1.DeleteOptions{})
+				}
+				if err != nil {
+					errs = append(errs, err.Error())
+				} else {
+					deleted = append(deleted, secretName)
+				}
+			}
+			if len(errs) > 0 {
+				setResultMessage(&as, strings.Join(errs, ", "))
 			} else {
-				setResultMessage(&as, fmt.Sprintf("Deleted secret %s in namespace %s", genName, ar.Request.Namespace))
+				setResultMessage(&as, fmt.Sprintf("Deleted secrets %s in namespace %s", strings.Join(deleted, ", "), ar.Request.Namespace))
 			}
 		}
 	}
 
+	recordWebhook(ar, as, start)
 	dispatchResponse(ar, as, w)
 }
 
 // loadSecret will load a secret from the remote secrets manager based on the annotations on the pod
-func loadSecret(name, namespace, remote string) (*core_v1.Secret, *kubernetes.Clientset, map[string]string, error) {
-	smc, _, err := loadClient()
+// When dryRun is set, any existing secret is left in place. The secret type annotation on the pod
+// takes precedence over any type set on the remote secret
+func loadSecret(genName, namespace, remote, secretType string, dryRun bool) (*core_v1.Secret, *kubernetes.Clientset, error) {
+	secret, err := serverClient.GetSecret(remote)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, err
 	}
 
-	secret, err := smc.GetSecret(remote)
+	if secretType == "" {
+		secretType = secret.Type
+	}
+	k8sType, data, err := clients.PrepareK8SSecretData(secretType, secret.Data)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, err
 	}
 
 	kc, err := clients.NewK8SClient(opts.IsPod, opts.KubeconfigPath)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, err
 	}
 
-	genName := fmt.Sprintf("%s-%s", release, name)
-
-	kc.CoreV1().Secrets(namespace).Delete(genName, &meta_v1.DeleteOptions{})
-
-	data := make(map[string][]byte)
-	for k, v := range secret.Data {
-		data[k] = []byte(v)
+	if !dryRun {
+		kc.CoreV1().Secrets(namespace).Delete(genName, &meta_v1.DeleteOptions{})
 	}
 
 	k8sSecret := &core_v1.Secret{
-		Type: core_v1.SecretTypeOpaque,
+		Type: k8sType,
 		Data: data,
 		ObjectMeta: meta_v1.ObjectMeta{
 			Name:      genName,
@@ -106,7 +150,7 @@ func loadSecret(name, namespace, remote string) (*core_v1.Secret, *kubernetes.Cl
 
 	k8sSecret.Annotations = map[string]string{clients.Hook: sOpts.Hook}
 
-	return k8sSecret, kc, secret.Data, nil
+	return k8sSecret, kc, nil
 }
 
 // addPatchReq adds a patchReq struct to a slice in a repeatable generic way
@@ -124,20 +168,39 @@ func addPatchReq(isNew func() bool, op string, path string, patchReqs *[]patchRe
 	*patchReqs = append(*patchReqs, req)
 }
 
+// addListPatchReqs adds values to a list in the pod spec. A missing list is added with all the
+// values at once, as appending to an existing list takes a single value per patch
+func addListPatchReqs(exists bool, path string, patchReqs *[]patchReq, values ...interface{}) {
+	if len(values) == 0 {
+		return
+	}
+	if !exists {
+		addPatchReq(func() bool { return true }, "add", path, patchReqs, values)
+		return
+	}
+	for _, value := range values {
+		addPatchReq(func() bool { return false }, "add", path, patchReqs, value)
+	}
+}
+
+// injectedSecret is a secret generated for a pod, along with how it is injected into the containers
+type injectedSecret struct {
+	podSecret *clients.PodSecret
+	k8sSecret *core_v1.Secret
+	envs      []core_v1.EnvVar
+}
+
 // runCreateHook is a split out function for running the steps when creating a pod
 func runCreateHook(w http.ResponseWriter, r *http.Request, ar *v1beta1.AdmissionReview, pod *core_v1.Pod, as *v1beta1.AdmissionResponse) error {
-	remote, path, local, runEnv, err := shouldMutate(pod)
+	podSecrets, err := shouldMutate(pod)
 	if err != nil {
 		return err
 	}
-	if remote == nil {
+	if len(podSecrets) == 0 {
 		return nil
 	}
 
 	podName := pod.Name
-	if local != nil && *local != "" {
-		podName = *local
-	}
 	if podName == "" {
 		podName = string(ar.Request.UID)
 	}
@@ -150,57 +213,103 @@ func runCreateHook(w http.ResponseWriter, r *http.Request, ar *v1beta1.Admission
 		namespace = "default"
 	}
 
-	k8sSecret, kc, data, err := loadSecret(podName, namespace, *remote)
-	if err != nil {
-		return err
+	var kc *kubernetes.Clientset
+	injected := make([]injectedSecret, 0)
+	secretNames := make([]string, 0)
+	for _, podSecret := range podSecrets {
+		if err := podSecret.Containers.Validate(pod.Spec); err != nil {
+			return err
+		}
+
+		genName := podSecret.SecretName(release, podName)
+		for _, secretName := range secretNames {
+			if secretName == genName {
+				return fmt.Errorf("More than one remote secret would be generated as secret %s", genName)
+			}
+		}
+
+		remotePath, err := podSecret.RemotePath(serverMgr)
+		if err != nil {
+			return err
+		}
+		var k8sSecret *core_v1.Secret
+		k8sSecret, kc, err = loadSecret(genName, namespace, remotePath, podSecret.Type, isDryRun(ar))
+		if err != nil {
+			return err
+		}
+		k8sSecret.Labels = map[string]string{clients.Hook: sOpts.Hook}
+		if pod.Name != "" && len(validation.IsValidLabelValue(pod.Name)) == 0 {
+			k8sSecret.Labels[clients.Pod] = pod.Name
+		}
+		// Pods have no uid until they are admitted, so the owner reference is always added later by the garbage collector
+
+		envs := make([]core_v1.EnvVar, 0)
+		if podSecret.Env {
+			envs, err = podSecret.EnvInjection.BuildEnvVars(k8sSecret.Name, k8sSecret.Data)
+			if err != nil {
+				return err
+			}
+		}
+
+		injected = append(injected, injectedSecret{podSecret: podSecret, k8sSecret: k8sSecret, envs: envs})
+		secretNames = append(secretNames, genName)
 	}
 
 	patchReqs := make([]patchReq, 0)
 
-	if path != nil {
-		vol := core_v1.Volume{Name: k8sSecret.Name}
-		vol.Secret = &core_v1.SecretVolumeSource{SecretName: k8sSecret.Name}
-		addPatchReq(func() bool { return len(pod.Spec.Volumes) == 0 }, "add", "/spec/volumes", &patchReqs, vol)
-	}
-
-	envs := make([]core_v1.EnvVar, 0)
-	if runEnv {
-		for k := range data {
-			selector := &core_v1.SecretKeySelector{Key: k}
-			selector.Name = k8sSecret.Name
-			envs = append(envs, core_v1.EnvVar{
-				Name: k,
-				ValueFrom: &core_v1.EnvVarSource{
-					SecretKeyRef: selector,
-				},
-			})
+	vols := make([]interface{}, 0)
+	for _, inj := range injected {
+		if inj.podSecret.Path != "" {
+			vol := core_v1.Volume{Name: inj.k8sSecret.Name}
+			vol.Secret = &core_v1.SecretVolumeSource{SecretName: inj.k8sSecret.Name}
+			vols = append(vols, vol)
 		}
 	}
+	addListPatchReqs(len(pod.Spec.Volumes) > 0, "/spec/volumes", &patchReqs, vols...)
 
-	containerPatches := func(specPath string, containers []core_v1.Container) {
+	containerPatches := func(specPath string, containers []core_v1.Container) error {
 		for idx, container := range containers {
-			if path != nil {
-				add := core_v1.VolumeMount{
-					Name:      k8sSecret.Name,
-					ReadOnly:  true,
-					MountPath: *path,
+			mounts := make([]interface{}, 0)
+			envs := make([]interface{}, 0)
+			envSources := make(map[string]string)
+			for _, inj := range injected {
+				if !inj.podSecret.Containers.Matches(container.Name) {
+					continue
+				}
+				if inj.podSecret.Path != "" {
+					mounts = append(mounts, core_v1.VolumeMount{
+						Name:      inj.k8sSecret.Name,
+						ReadOnly:  true,
+						MountPath: inj.podSecret.Path,
+					})
+				}
+				for _, env := range inj.envs {
+					if other, ok := envSources[env.Name]; ok {
+						return fmt.Errorf("Secrets %s and %s would both inject the env var %s into container %s", other, inj.k8sSecret.Name, env.Name, container.Name)
+					}
+					envSources[env.Name] = inj.k8sSecret.Name
+					envs = append(envs, env)
 				}
-				addPatchReq(func() bool { return len(container.VolumeMounts) == 0 }, "add", fmt.Sprintf("/spec/%s/%d/volumeMounts", specPath, idx), &patchReqs, add)
-			}
-			if len(envs) > 0 {
-				addPatchReq(func() bool { return len(container.Env) == 0 }, "add", fmt.Sprintf("/spec/%s/%d/env", specPath, idx), &patchReqs, envs)
 			}
+			addListPatchReqs(len(container.VolumeMounts) > 0, fmt.Sprintf("/spec/%s/%d/volumeMounts", specPath, idx), &patchReqs, mounts...)
+			addListPatchReqs(len(container.Env) > 0, fmt.Sprintf("/spec/%s/%d/env", specPath, idx), &patchReqs, envs...)
 		}
+		return nil
 	}
 
-	containerPatches("containers", pod.Spec.Containers)
-	containerPatches("initContainers", pod.Spec.InitContainers)
+	if err := containerPatches("containers", pod.Spec.Containers); err != nil {
+		return err
+	}
+	if err := containerPatches("initContainers", pod.Spec.InitContainers); err != nil {
+		return err
+	}
 
 	annotations := make(map[string]string)
 	if len(pod.Annotations) > 0 {
 		annotations = pod.Annotations
 	}
 	annotations[clients.Managed] = "true"
+	annotations[clients.Secrets] = strings.Join(secretNames, ",")
 	addPatchReq(func() bool { return true }, "add", "/metadata/annotations", &patchReqs, annotations)
 
 	patchData, err := json.Marshal(patchReqs)
@@ -214,10 +323,18 @@ func runCreateHook(w http.ResponseWriter, r *http.Request, ar *v1beta1.Admission
 		return &p
 	}()
 
-	if _, err := kc.CoreV1().Secrets(namespace).Create(k8sSecret); err != nil {
-		return err
+	// The webhook is registered with sideEffects NoneOnDryRun, so the pod is still patched but no secret is written
+	if isDryRun(ar) {
+		setResultMessage(as, fmt.Sprintf("Dry run, secrets %s in namespace %s not created", strings.Join(secretNames, ", "), namespace))
+		return nil
 	}
-	setResultMessage(as, fmt.Sprintf("Created secret %s in namespace %s", k8sSecret.Name, namespace))
+
+	for _, inj := range injected {
+		if _, err := kc.CoreV1().Secrets(namespace).Create(inj.k8sSecret); err != nil {
+			return err
+		}
+	}
+	setResultMessage(as, fmt.Sprintf("Created secrets %s in namespace %s", strings.Join(secretNames, ", "), namespace))
 	return nil
 }
 
@@ -227,9 +344,22 @@ func readRequest(body io.ReadCloser) (*v1beta1.AdmissionReview, *core_v1.Pod, er
 	if err := json.NewDecoder(body).Decode(&ar); err != nil {
 		return nil, nil, err
 	}
-	if len(ar.Request.Object.Raw) > 0 {
+	switch ar.APIVersion {
+	case admissionV1, admissionV1beta1, "":
+	default:
+		return &ar, nil, fmt.Errorf("Unsupported AdmissionReview version %s", ar.APIVersion)
+	}
+	if ar.Request == nil {
+		return &ar, nil, errors.New("AdmissionReview is missing a request")
+	}
+	// Pods being deleted are only sent as the old object
+	raw := ar.Request.Object.Raw
+	if len(raw) == 0 {
+		raw = ar.Request.OldObject.Raw
+	}
+	if len(raw) > 0 {
 		var pod core_v1.Pod
-		if err := json.Unmarshal(ar.Request.Object.Raw, &pod); err != nil {
+		if err := json.Unmarshal(raw, &pod); err != nil {
 			return nil, nil, err
 		}
 		return &ar, &pod, nil
@@ -238,46 +368,68 @@ func readRequest(body io.ReadCloser) (*v1beta1.AdmissionReview, *core_v1.Pod, er
 }
 
 // shouldMutate will scan the pod for the desired annotations
-// If the annotations exist, return the paths we need to progress with the secrets creation
-func shouldMutate(pod *core_v1.Pod) (*string, *string, *string, bool, error) {
-
-	isHook := false
-	remote := ""
-	path := ""
-	env := "false"
-	local := ""
-
-	for k, v := range pod.Annotations {
-		switch k {
-		case clients.Hook:
-			if v == sOpts.Hook {
-				isHook = true
-			}
-		case clients.Remote:
-			remote = v
-		case clients.Path:
-			path = v
-		case clients.Env:
-			env = v
-		case clients.Local:
-			local = v
-		}
+// If the annotations exist, return the remote secrets we need to progress with the secrets creation
+func shouldMutate(pod *core_v1.Pod) ([]*clients.PodSecret, error) {
+	if pod == nil || pod.Annotations[clients.Hook] != sOpts.Hook {
+		return nil, nil
 	}
 
-	runEnv, _ := strconv.ParseBool(env)
+	podSecrets, err := clients.ParsePodSecrets(pod.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	if len(podSecrets) == 0 {
+		return nil, errors.New("Missing properties for remote secret name")
+	}
+	return podSecrets, nil
+}
 
-	if !isHook {
-		return nil, nil, nil, false, nil
+// deletedPodSecrets provides the names of the secrets generated for a pod being deleted. These are
+// listed on the pod when it is created, but if the api server does not send the pod, the name of
+// the secret for the plain annotations is assumed
+func deletedPodSecrets(ar *v1beta1.AdmissionReview, pod *core_v1.Pod) []string {
+	secretNames := make([]string, 0)
+	if pod != nil {
+		for _, secretName := range strings.Split(pod.Annotations[clients.Secrets], ",") {
+			if secretName = strings.TrimSpace(secretName); secretName != "" {
+				secretNames = append(secretNames, secretName)
+			}
+		}
+	}
+	if len(secretNames) == 0 {
+		secretNames = append(secretNames, fmt.Sprintf("%s-%s", release, ar.Request.Name))
 	}
+	return secretNames
+}
 
-	if remote == "" {
-		return nil, nil, nil, false, errors.New("Missing properties for remote secret name")
+// runPodSecretGC keeps removing the secrets generated by the hook whose pod no longer exists, and
+// setting the pods that do exist as the owners of their secrets. The first collection also picks up
+// the secrets generated before the hook labelled them
+func runPodSecretGC() {
+	kc, err := clients.NewK8SClient(opts.IsPod, opts.KubeconfigPath)
+	if err != nil {
+		log.Printf("Failed to load a k8s client, generated secrets will not be collected: %s\n", err.Error())
+		return
 	}
+	legacy := true
+	for {
+		time.Sleep(sOpts.GCInterval)
 
-	if path != "" {
-		return &remote, &path, &local, runEnv, nil
+		deleted, adopted, err := clients.CollectPodSecrets(kc, sOpts.Hook, release, sOpts.GCGrace, legacy)
+		if err != nil {
+			log.Printf("Failed to collect generated secrets: %s\n", err.Error())
+			continue
+		}
+		legacy = false
+		if deleted > 0 || adopted > 0 {
+			log.Printf("Collected generated secrets: %d orphaned deleted, %d given pod owners\n", deleted, adopted)
+		}
 	}
-	return &remote, nil, &local, runEnv, nil
+}
+
+// isDryRun reports whether the api server has asked for the request to be made without side effects
+func isDryRun(ar *v1beta1.AdmissionReview) bool {
+	return ar.Request.DryRun != nil && *ar.Request.DryRun
 }
 
 // setResultMessage adds a message to the response struct, can be errors or otherwise
@@ -289,9 +441,16 @@ func setResultMessage(as *v1beta1.AdmissionResponse, message string) {
 // dispatchResponse writes out the json response payload from the hook
 func dispatchResponse(ar *v1beta1.AdmissionReview, as v1beta1.AdmissionResponse, w http.ResponseWriter) {
 	resp := v1beta1.AdmissionReview{}
+	resp.APIVersion = admissionV1beta1
+	resp.Kind = "AdmissionReview"
 	resp.Response = &as
-	if ar.Request != nil {
-		resp.Response.UID = ar.Request.UID
+	if ar != nil {
+		if ar.APIVersion != "" {
+			resp.APIVersion = ar.APIVersion
+		}
+		if ar.Request != nil {
+			resp.Response.UID = ar.Request.UID
+		}
 	}
 	payloadjson, err := json.Marshal(resp)
 	if err != nil {
@@ -305,32 +464,37 @@ func dispatchResponse(ar *v1beta1.AdmissionReview, as v1beta1.AdmissionResponse,
 func shutdownServer(srv *http.Server) {
 	log.Println("Server shutdown started. Will try to cleanup dynamic resources")
 
-	kc, err := clients.NewK8SClient(opts.IsPod, opts.KubeconfigPath)
+	dc, err := clients.NewK8SDynamicClient(opts.IsPod, opts.KubeconfigPath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	log.Printf("Using release %s\n", release)
 
-	whs, err := kc.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().List(meta_v1.ListOptions{LabelSelector: fmt.Sprintf("app=%s", release)})
-	if err != nil {
-		log.Println(err.Error())
-	} else {
-		for _, whs := range whs.Items {
-			log.Printf("Removing mutating webhook configuration %s\n", whs.Name)
-			kc.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Delete(whs.Name, &meta_v1.DeleteOptions{})
+	deleteReleaseResources(dc, "mutating webhook configuration", webhookResources)
+	deleteReleaseResources(dc, "csr", csrResources)
+
+	srv.Shutdown(context.Background())
+}
+
+// deleteReleaseResources removes the cluster scoped objects labelled with the release, using the first of the api versions the cluster serves
+func deleteReleaseResources(dc dynamic.Interface, kind string, versions []schema.GroupVersionResource) {
+	listOpts := meta_v1.ListOptions{LabelSelector: fmt.Sprintf("app=%s", release)}
+
+	var err error
+	for _, gvr := range versions {
+		var items *unstructured.UnstructuredList
+		items, err = dc.Resource(gvr).List(listOpts)
+		if err != nil {
+			continue
+		}
+		for _, item := range items.Items {
+			log.Printf("Removing %s %s\n", kind, item.GetName())
+			dc.Resource(gvr).Delete(item.GetName(), &meta_v1.DeleteOptions{})
 		}
+		return
 	}
-
-	csr, err := kc.CertificatesV1beta1().CertificateSigningRequests().List(meta_v1.ListOptions{LabelSelector: fmt.Sprintf("app=%s", release)})
 	if err != nil {
 		log.Println(err.Error())
-	} else {
-		for _, csr := range csr.Items {
-			log.Printf("Removing csr %s\n", csr.Name)
-			kc.CertificatesV1beta1().CertificateSigningRequests().Delete(csr.Name, &meta_v1.DeleteOptions{})
-		}
 	}
-
-	srv.Shutdown(context.Background())
 }